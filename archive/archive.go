@@ -0,0 +1,261 @@
+// Package archive keeps conversations with strangers in a bbolt database so
+// that they can be searched later
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/GiedriusS/gomegle"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket holding the conversations. Keys are the start time in nanoseconds
+// followed by the ID, both big-endian, so that they are sorted by time
+var conversationsBucket = []byte("conversations")
+
+// Mode of a conversation
+type Mode string
+
+// Modes of conversations
+const (
+	Text  Mode = "text"  // Talking to a stranger
+	Spyee Mode = "spyee" // Answering a question with another stranger
+	Spy   Mode = "spy"   // Watching two strangers discuss our question
+)
+
+// Reasons a conversation ended for
+const (
+	YouDisconnected      = "you disconnected"
+	StrangerDisconnected = "stranger disconnected"
+	ConnectionDied       = "connection died"
+	Banned               = "banned"
+	Lost                 = "lost" // The session ended without saying why
+)
+
+// Message is a message in a conversation
+type Message struct {
+	Time time.Time `json:"time"`
+	From string    `json:"from"` // "You", "Stranger", "Stranger 1" or "Stranger 2"
+	Text string    `json:"text"`
+}
+
+// Conversation is an archived conversation with a stranger, or between two
+// strangers when spying
+type Conversation struct {
+	ID           uint64    `json:"-"` // Set by Add
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Mode         Mode      `json:"mode"`
+	Question     string    `json:"question,omitempty"` // Discussed by the strangers
+	Topics       []string  `json:"topics,omitempty"`   // That we looked for strangers with
+	CommonLikes  []string  `json:"common_likes,omitempty"`
+	College      string    `json:"college,omitempty"` // Of the stranger
+	Reason       string    `json:"reason"`            // Why it ended, such as StrangerDisconnected
+	IdentDigests string    `json:"ident_digests,omitempty"`
+	Messages     []Message `json:"messages,omitempty"`
+}
+
+// Duration returns how long the conversation took
+func (c *Conversation) Duration() time.Duration {
+	return c.End.Sub(c.Start)
+}
+
+// FromTranscript converts the conversations in a transcript into
+// Conversations. o is the configuration the session was started with, its
+// topics and mode are archived. Conversations in which no stranger was
+// found are skipped
+func FromTranscript(o *gomegle.Omegle, t *gomegle.Transcript) (convs []*Conversation) {
+	mode := Text
+	if o.Question != "" {
+		mode = Spy
+	} else if o.Wantsspy {
+		mode = Spyee
+	}
+
+	for _, part := range t.Conversations() {
+		records := part.Records()
+		c := &Conversation{Mode: mode, Topics: o.Topics, Reason: Lost}
+		connected := false
+		for _, r := range records {
+			switch ev := r.Event.(type) {
+			case gomegle.MessageEvent:
+				from := "Stranger"
+				if r.Sent {
+					from = "You"
+				}
+				c.Messages = append(c.Messages, Message{r.Time, from, ev.Text})
+			case gomegle.SpyMessageEvent:
+				c.Messages = append(c.Messages, Message{r.Time, ev.Who, ev.Text})
+			case gomegle.QuestionEvent:
+				c.Question = ev.Text
+			case gomegle.CommonLikesEvent:
+				c.CommonLikes = ev.Topics
+			case gomegle.PartnerCollegeEvent:
+				c.College = ev.College
+			case gomegle.IdentDigestsEvent:
+				c.IdentDigests = ev.Digests
+			case gomegle.SpyDisconnectedEvent:
+				c.Reason = ev.Who + " disconnected"
+			case gomegle.ErrorEvent:
+				c.Reason = "error: " + ev.Text
+			case gomegle.Event:
+				switch {
+				case ev == gomegle.CONNECTED && !connected:
+					connected = true
+					c.Start = r.Time
+				case ev == gomegle.DISCONNECTED && r.Sent:
+					c.Reason = YouDisconnected
+				case ev == gomegle.DISCONNECTED:
+					c.Reason = StrangerDisconnected
+				case ev == gomegle.CONNECTIONDIED:
+					c.Reason = ConnectionDied
+				case ev == gomegle.ANTINUDEBANNED:
+					c.Reason = Banned
+				}
+			}
+		}
+		if !connected {
+			continue
+		}
+		c.End = records[len(records)-1].Time
+		convs = append(convs, c)
+	}
+	return convs
+}
+
+// Archive is a database of conversations. It is safe for concurrent use,
+// but only one process can have it open at a time
+type Archive struct {
+	db *bolt.DB
+}
+
+// Open opens the archive in the file at path, creating it if needed. It
+// fails if another process does not close the archive within a second
+func Open(path string) (*Archive, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(conversationsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Archive{db}, nil
+}
+
+// Close closes the archive
+func (a *Archive) Close() error {
+	return a.db.Close()
+}
+
+// Key of a conversation started at start
+func key(start time.Time, id uint64) []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint64(k, uint64(start.UnixNano()))
+	binary.BigEndian.PutUint64(k[8:], id)
+	return k
+}
+
+// Add stores c and sets its ID
+func (a *Archive) Add(c *Conversation) error {
+	return a.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(conversationsBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(key(c.Start, id), data); err != nil {
+			return err
+		}
+		c.ID = id
+		return nil
+	})
+}
+
+// Query selects conversations in Search. The zero value selects everything
+type Query struct {
+	Text   string    // Words that all have to be in the messages or the question, in any case
+	From   time.Time // Optional, only conversations started at or after this
+	To     time.Time // Optional, only conversations started before this
+	Topics []string  // Topics that all have to be among the topics or common likes
+	Limit  int       // Optional, at most this many of the latest matches are returned
+}
+
+// Whether c matches q. words are the lower case words of q.Text
+func (q *Query) match(c *Conversation, words []string) bool {
+	for _, topic := range q.Topics {
+		found := false
+		for _, t := range append(append([]string(nil), c.Topics...), c.CommonLikes...) {
+			if strings.EqualFold(t, topic) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(words) == 0 {
+		return true
+	}
+	var text strings.Builder
+	text.WriteString(strings.ToLower(c.Question))
+	for _, m := range c.Messages {
+		text.WriteByte('\n')
+		text.WriteString(strings.ToLower(m.Text))
+	}
+	for _, w := range words {
+		if !strings.Contains(text.String(), w) {
+			return false
+		}
+	}
+	return true
+}
+
+// Search returns the conversations matching q, oldest first
+func (a *Archive) Search(q Query) (convs []*Conversation, err error) {
+	words := strings.Fields(strings.ToLower(q.Text))
+	err = a.db.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(conversationsBucket).Cursor()
+		k, v := cur.First()
+		if !q.From.IsZero() {
+			k, v = cur.Seek(key(q.From, 0))
+		}
+		var to []byte
+		if !q.To.IsZero() {
+			to = key(q.To, 0)
+		}
+
+		for ; k != nil; k, v = cur.Next() {
+			if to != nil && bytes.Compare(k, to) >= 0 {
+				break
+			}
+			c := &Conversation{}
+			if err := json.Unmarshal(v, c); err != nil {
+				return err
+			}
+			c.ID = binary.BigEndian.Uint64(k[8:])
+			if q.match(c, words) {
+				convs = append(convs, c)
+			}
+		}
+		return nil
+	})
+	if q.Limit > 0 && len(convs) > q.Limit {
+		convs = convs[len(convs)-q.Limit:]
+	}
+	return convs, err
+}
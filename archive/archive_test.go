@@ -0,0 +1,120 @@
+package archive
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GiedriusS/gomegle"
+)
+
+// Two conversations, the first with a stranger who left and the second one
+// lost halfway
+const transcript = `{"time":"2014-06-01T12:00:00Z","conversation":0,"event":["waiting"]}
+{"time":"2014-06-01T12:00:01Z","conversation":0,"event":["connected"]}
+{"time":"2014-06-01T12:00:01Z","conversation":0,"event":["commonLikes",["cats"]]}
+{"time":"2014-06-01T12:00:01Z","conversation":0,"event":["identDigests","a,b,c,d"]}
+{"time":"2014-06-01T12:00:02Z","conversation":0,"event":["partnerCollege","ktu.edu"]}
+{"time":"2014-06-01T12:00:05Z","conversation":0,"event":["gotMessage","hi, do you like Cats?"]}
+{"time":"2014-06-01T12:00:09Z","conversation":0,"sent":true,"event":["gotMessage","sure"]}
+{"time":"2014-06-01T12:01:01Z","conversation":0,"event":["strangerDisconnected"]}
+{"time":"2014-06-01T12:02:00Z","conversation":1,"event":["waiting"]}
+{"time":"2014-06-01T12:02:01Z","conversation":1,"event":["connected"]}
+{"time":"2014-06-01T12:02:03Z","conversation":1,"event":["gotMessage","bye"]}
+`
+
+func readTranscript(t *testing.T) *gomegle.Transcript {
+	tr, err := gomegle.ReadJSONL(strings.NewReader(transcript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func TestFromTranscript(t *testing.T) {
+	o := &gomegle.Omegle{Topics: []string{"cats", "dogs"}}
+	convs := FromTranscript(o, readTranscript(t))
+	if len(convs) != 2 {
+		t.Fatal("expected 2 conversations, got ", len(convs))
+	}
+
+	c := convs[0]
+	if c.Mode != Text || c.College != "ktu.edu" || c.IdentDigests != "a,b,c,d" || c.Reason != StrangerDisconnected {
+		t.Errorf("wrong metadata: %+v", c)
+	}
+	if !reflect.DeepEqual(c.Topics, o.Topics) || !reflect.DeepEqual(c.CommonLikes, []string{"cats"}) {
+		t.Errorf("wrong topics %v and common likes %v", c.Topics, c.CommonLikes)
+	}
+	if c.Duration() != time.Minute {
+		t.Error("wrong duration: ", c.Duration())
+	}
+	if len(c.Messages) != 2 || c.Messages[0].From != "Stranger" || c.Messages[1].From != "You" {
+		t.Errorf("wrong messages: %v", c.Messages)
+	}
+	if convs[1].Reason != Lost {
+		t.Error("wrong reason for the second conversation: ", convs[1].Reason)
+	}
+
+	if FromTranscript(&gomegle.Omegle{Question: "Why?"}, readTranscript(t))[0].Mode != Spy {
+		t.Error("asking a question is not spying")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	a, err := Open(filepath.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	convs := FromTranscript(&gomegle.Omegle{Topics: []string{"cats"}}, readTranscript(t))
+	later := &Conversation{Start: convs[0].Start.Add(24 * time.Hour), Mode: Spyee, Question: "Cats or dogs?", CommonLikes: []string{"dogs"}}
+	for _, c := range append(convs, later) {
+		if err := a.Add(c); err != nil {
+			t.Fatal(err)
+		}
+		if c.ID == 0 {
+			t.Error("no ID set by Add")
+		}
+	}
+
+	day := time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		q    Query
+		want []*Conversation
+	}{
+		{Query{}, []*Conversation{convs[0], convs[1], later}},
+		{Query{Text: "CATS"}, []*Conversation{convs[0], later}},
+		{Query{Text: "like cats"}, []*Conversation{convs[0]}},
+		{Query{Text: "cats", From: day.Add(24 * time.Hour)}, []*Conversation{later}},
+		{Query{From: day, To: day.Add(24 * time.Hour)}, []*Conversation{convs[0], convs[1]}},
+		{Query{Topics: []string{"dogs"}}, []*Conversation{later}},
+		{Query{Topics: []string{"Cats"}, Text: "bye"}, []*Conversation{convs[1]}},
+		{Query{Limit: 1}, []*Conversation{later}},
+	} {
+		got, err := a.Search(test.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotIDs, wantIDs []uint64
+		for _, c := range got {
+			gotIDs = append(gotIDs, c.ID)
+		}
+		for _, c := range test.want {
+			wantIDs = append(wantIDs, c.ID)
+		}
+		if !reflect.DeepEqual(gotIDs, wantIDs) {
+			t.Errorf("%+v: got %v, want %v", test.q, gotIDs, wantIDs)
+		}
+	}
+
+	got, err := a.Search(Query{Text: "sure"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !reflect.DeepEqual(got[0].Messages, convs[0].Messages) {
+		t.Error("messages were not stored")
+	}
+}
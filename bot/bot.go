@@ -0,0 +1,279 @@
+// Package bot helps writing bots that chat with strangers. Handlers for the
+// events of a conversation are added to the Router of a Bot, which runs
+// them and finds a new stranger whenever a conversation ends
+package bot
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/GiedriusS/gomegle"
+)
+
+// Middleware changes a message on its way in or out. It returns the text to
+// pass on and false to drop the message instead
+type Middleware func(c *Conversation, text string) (string, bool)
+
+// Router holds the handlers for the events of conversations. Handlers of
+// the same kind run in the order they were added. All handlers run one at a
+// time in the goroutine of Bot.Run, so a slow handler delays the events
+// after it. Add handlers before calling Run
+type Router struct {
+	connect     []func(c *Conversation)
+	message     []func(c *Conversation, text string)
+	typing      []func(c *Conversation, typing bool)
+	disconnect  []func(c *Conversation)
+	question    []func(c *Conversation, question string)
+	commonLikes []func(c *Conversation, topics []string)
+}
+
+// OnConnect adds a handler called when a stranger connects
+func (r *Router) OnConnect(h func(c *Conversation)) {
+	r.connect = append(r.connect, h)
+}
+
+// OnMessage adds a handler called with every message of the stranger that
+// passed the inbound middleware
+func (r *Router) OnMessage(h func(c *Conversation, text string)) {
+	r.message = append(r.message, h)
+}
+
+// OnTyping adds a handler called when the stranger starts or stops typing
+func (r *Router) OnTyping(h func(c *Conversation, typing bool)) {
+	r.typing = append(r.typing, h)
+}
+
+// OnDisconnect adds a handler called when a conversation in which a
+// stranger connected ends, whoever ended it
+func (r *Router) OnDisconnect(h func(c *Conversation)) {
+	r.disconnect = append(r.disconnect, h)
+}
+
+// OnQuestion adds a handler called with the question we and the stranger
+// were asked to discuss as spyees
+func (r *Router) OnQuestion(h func(c *Conversation, question string)) {
+	r.question = append(r.question, h)
+}
+
+// OnCommonLikes adds a handler called with the topics we share with the
+// stranger
+func (r *Router) OnCommonLikes(h func(c *Conversation, topics []string)) {
+	r.commonLikes = append(r.commonLikes, h)
+}
+
+// Bot talks to strangers using the handlers of its Router. The zero value
+// talks to a single stranger with the default configuration and does
+// nothing
+type Bot struct {
+	Router
+	// Optional, the configuration conversations are started with. Sessions
+	// do not reconnect by themselves, set Rematch instead. The backoff and
+	// MaxAttempts of its Reconnect policy are used to wait between rematches
+	Omegle   *gomegle.Omegle
+	Inbound  []Middleware // Optional, run in order on every message of the stranger before the OnMessage handlers
+	Outbound []Middleware // Optional, run in order on every message sent with Conversation.Send
+	// Optional, if not nil then Conversation.Send types the messages with it
+	// instead of sending them at once
+	Typing *gomegle.TypingSimulator
+	// Optional, if true then a new stranger is found whenever a conversation
+	// ends, until ctx is done. Every rematch waits like a ReconnectPolicy
+	// would, longer after every conversation in a row in which no stranger
+	// connected
+	Rematch bool
+}
+
+// Conversation is a conversation with one stranger as seen by the
+// handlers. Its methods are safe to call from any goroutine
+type Conversation struct {
+	bot *Bot
+	s   *gomegle.Session
+	ctx context.Context
+	n   int
+
+	m     sync.Mutex
+	state map[string]interface{}
+}
+
+// Session returns the session of the conversation
+func (c *Conversation) Session() *gomegle.Session {
+	return c.s
+}
+
+// Context returns a context that is done when Bot.Run stops
+func (c *Conversation) Context() context.Context {
+	return c.ctx
+}
+
+// N returns which conversation of the bot this is, counted from 1
+func (c *Conversation) N() int {
+	return c.n
+}
+
+// Get returns the value stored under key in this conversation, nil if none
+func (c *Conversation) Get(key string) interface{} {
+	defer c.m.Unlock()
+	c.m.Lock()
+	return c.state[key]
+}
+
+// Set stores a value under key for the rest of this conversation
+func (c *Conversation) Set(key string, v interface{}) {
+	defer c.m.Unlock()
+	c.m.Lock()
+	if c.state == nil {
+		c.state = make(map[string]interface{})
+	}
+	c.state[key] = v
+}
+
+// Send sends a message to the stranger after passing it through the
+// outbound middleware. Nothing is sent if the middleware drops it. With
+// Bot.Typing set it returns once the message is typed and sent
+func (c *Conversation) Send(text string) error {
+	for _, m := range c.bot.Outbound {
+		var ok bool
+		if text, ok = m(c, text); !ok {
+			return nil
+		}
+	}
+	if c.bot.Typing != nil {
+		return c.bot.Typing.Send(c.ctx, c.s, text)
+	}
+	return c.s.SendMessageContext(c.ctx, text)
+}
+
+// Typing shows the stranger whether we are typing
+func (c *Conversation) Typing(typing bool) error {
+	if typing {
+		return c.s.ShowTypingContext(c.ctx)
+	}
+	return c.s.StopTypingContext(c.ctx)
+}
+
+// Leave disconnects from the stranger. The OnDisconnect handlers run and a
+// new stranger is found if Rematch is set
+func (c *Conversation) Leave() error {
+	return c.s.Close()
+}
+
+// Call the handlers for an event. Returns true if a stranger connected
+func (b *Bot) dispatch(c *Conversation, ev gomegle.EventData) bool {
+	switch ev := ev.(type) {
+	case gomegle.MessageEvent:
+		text := ev.Text
+		for _, m := range b.Inbound {
+			var ok bool
+			if text, ok = m(c, text); !ok {
+				return false
+			}
+		}
+		for _, h := range b.message {
+			h(c, text)
+		}
+	case gomegle.QuestionEvent:
+		for _, h := range b.question {
+			h(c, ev.Text)
+		}
+	case gomegle.CommonLikesEvent:
+		for _, h := range b.commonLikes {
+			h(c, ev.Topics)
+		}
+	case gomegle.Event:
+		switch ev {
+		case gomegle.CONNECTED:
+			for _, h := range b.connect {
+				h(c)
+			}
+			return true
+		case gomegle.TYPING, gomegle.STOPPEDTYPING:
+			for _, h := range b.typing {
+				h(c, ev == gomegle.TYPING)
+			}
+		}
+	}
+	return false
+}
+
+// Run the handlers for the events of a conversation until it ends. Returns
+// true if a stranger connected
+func (b *Bot) converse(ctx context.Context, c *Conversation) bool {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.s.Close()
+		case <-done:
+		}
+	}()
+
+	connected := false
+	for ev := range c.s.Events() {
+		if b.dispatch(c, ev) {
+			connected = true
+		}
+	}
+	c.s.Close()
+	if connected {
+		for _, h := range b.disconnect {
+			h(c)
+		}
+	}
+	return connected
+}
+
+// Run talks to strangers until ctx is done or, unless Rematch is set, the
+// first conversation ends. It returns ctx's error if ctx is done, the error
+// of the last conversation if Rematch is not set or the Reconnect policy's
+// MaxAttempts conversations in a row ended before a stranger connected, and
+// the error of starting a conversation if that fails
+func (b *Bot) Run(ctx context.Context) error {
+	var policy *gomegle.ReconnectPolicy
+	if b.Omegle != nil {
+		policy = b.Omegle.Reconnect
+	}
+	failures := 0 // Conversations in a row in which no stranger connected
+	for n := 1; ; n++ {
+		conf := &gomegle.Omegle{}
+		if b.Omegle != nil {
+			conf = b.Omegle.Config()
+		}
+		conf.Reconnect = nil
+
+		s, err := conf.Start(ctx)
+		if ctx.Err() != nil {
+			if err == nil {
+				s.Close()
+			}
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+
+		connected := b.converse(ctx, &Conversation{bot: b, s: s, ctx: ctx, n: n})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !b.Rematch {
+			return s.Err()
+		}
+
+		if connected {
+			failures = 0
+		} else {
+			failures++
+		}
+		if policy != nil && policy.MaxAttempts != 0 && failures >= policy.MaxAttempts {
+			return s.Err()
+		}
+		timer := time.NewTimer(policy.Backoff(failures + 1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
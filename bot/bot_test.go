@@ -0,0 +1,134 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GiedriusS/gomegle"
+	"github.com/GiedriusS/gomegle/gomegletest"
+)
+
+func TestBot(t *testing.T) {
+	srv := gomegletest.NewServer()
+	defer srv.Close()
+
+	var m sync.Mutex
+	var log []string
+	logf := func(s string) {
+		defer m.Unlock()
+		m.Lock()
+		log = append(log, s)
+	}
+
+	b := &Bot{
+		Omegle:  &gomegle.Omegle{Client: &http.Client{Transport: srv.Transport()}, Topics: []string{"go"}},
+		Rematch: true,
+		Inbound: []Middleware{func(c *Conversation, text string) (string, bool) {
+			return strings.ToLower(text), text != "spam"
+		}},
+		Outbound: []Middleware{func(c *Conversation, text string) (string, bool) {
+			return text + "!", text != "secret"
+		}},
+	}
+	b.OnConnect(func(c *Conversation) {
+		c.Set("heard", 0)
+		c.Send("hi")
+		logf("connect")
+	})
+	b.OnCommonLikes(func(c *Conversation, topics []string) { logf("likes " + strings.Join(topics, ",")) })
+	b.OnTyping(func(c *Conversation, typing bool) {
+		if typing {
+			logf("typing")
+		}
+	})
+	b.OnMessage(func(c *Conversation, text string) {
+		c.Set("heard", c.Get("heard").(int)+1)
+		c.Send("secret")
+		c.Send("you said " + text)
+	})
+	b.OnDisconnect(func(c *Conversation) {
+		logf("disconnect")
+		if c.Get("heard") != 1 {
+			t.Error("wrong number of messages heard: ", c.Get("heard"))
+		}
+		if c.N() == 2 {
+			c.Set("done", true)
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- b.Run(ctx) }()
+
+	first := srv.WaitChat(0, time.Second)
+	first.Typing()
+	first.Send("spam")
+	first.Send("HELLO")
+	if got, want := first.WaitMessages(2, time.Second), []string{"hi!", "you said hello!"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got messages %v, want %v", got, want)
+	}
+	first.Disconnect()
+
+	second := srv.WaitChat(1, time.Second)
+	if second == nil {
+		t.Fatal("did not find a new stranger")
+	}
+	second.Send("again")
+	second.WaitMessages(2, time.Second)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Error("Run returned ", err)
+	}
+	if !second.Ended() {
+		t.Error("Run did not disconnect when ctx was done")
+	}
+
+	want := []string{"connect", "likes go", "typing", "disconnect", "connect", "likes go", "disconnect"}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("got %v, want %v", log, want)
+	}
+}
+
+func TestBotLeave(t *testing.T) {
+	srv := gomegletest.NewServer()
+	defer srv.Close()
+
+	b := &Bot{Omegle: &gomegle.Omegle{Client: &http.Client{Transport: srv.Transport()}}}
+	b.OnConnect(func(c *Conversation) { c.Leave() })
+	if err := b.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.Chats()) != 1 || !srv.Chats()[0].Ended() {
+		t.Error("did not leave the only conversation")
+	}
+}
+
+func TestBotRematchBackoff(t *testing.T) {
+	srv := gomegletest.NewServer()
+	defer srv.Close()
+	srv.Fault("", "events", http.StatusOK, `[["error", "You have been banned"]]`)
+
+	b := &Bot{
+		Omegle: &gomegle.Omegle{
+			Client:    &http.Client{Transport: srv.Transport()},
+			Reconnect: &gomegle.ReconnectPolicy{MinBackoff: 100 * time.Millisecond, MaxAttempts: 3},
+		},
+		Rematch: true,
+	}
+	start := time.Now()
+	if err := b.Run(context.Background()); err == nil {
+		t.Error("expected the error of the last conversation, got nil")
+	}
+	if n := len(srv.Chats()); n != 3 {
+		t.Errorf("started %d conversations, want 3", n)
+	}
+	// 200ms after the first failure and 400ms after the second
+	if d := time.Since(start); d < 600*time.Millisecond {
+		t.Errorf("rematched too fast, in %v", d)
+	}
+}
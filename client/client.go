@@ -2,55 +2,398 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/GiedriusS/gomegle"
+	"github.com/nsf/termbox-go"
 	"log"
+	"net"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
 	"time"
 )
 
-func messageListener(o *gomegle.Omegle, logger *log.Logger) {
+// current holds the session the user is talking in
+type current struct {
+	m        sync.Mutex
+	s        *gomegle.Session
+	recorded *gomegle.Transcript // Of the last session a stranger connected in
+}
+
+// Change the current session
+func (c *current) set(s *gomegle.Session) {
+	defer c.m.Unlock()
+	c.m.Lock()
+	c.s = s
+}
+
+// Get the current session
+func (c *current) get() *gomegle.Session {
+	defer c.m.Unlock()
+	c.m.Lock()
+	return c.s
+}
+
+// Remember the transcript of the current session once a stranger connected
+// so that it can still be saved while the next stranger is searched for
+func (c *current) connected() {
+	defer c.m.Unlock()
+	c.m.Lock()
+	c.recorded = c.s.Transcript()
+}
+
+// Get the transcript of the last session a stranger connected in
+func (c *current) transcript() *gomegle.Transcript {
+	defer c.m.Unlock()
+	c.m.Lock()
+	return c.recorded
+}
+
+// plainChat is the conversation in plain mode
+type plainChat struct {
+	cur  *current
+	stop func() // Quits the client
+}
+
+func (pc *plainChat) println(line string) {
+	fmt.Println(line)
+}
+
+func (pc *plainChat) session() *gomegle.Session {
+	return pc.cur.get()
+}
+
+// The main loop starts a new session once the current one is closed
+func (pc *plainChat) next() {
+	if s := pc.cur.get(); s != nil {
+		s.Close()
+	}
+}
+
+func (pc *plainChat) quit() {
+	pc.stop()
+}
+
+// The transcript of the last session, which is kept after it ended
+func (pc *plainChat) transcript() *gomegle.Transcript {
+	return pc.cur.transcript()
+}
+
+func messageListener(pc *plainChat, st *settings, logger *log.Logger) {
+	reader := bufio.NewReader(os.Stdin)
 	for {
-		err := o.ShowTyping()
-		if err != nil {
-			logger.Print(err)
+		// Without a typist we cannot tell when the user starts typing, so
+		// the stranger sees us typing whenever we are not sending
+		if st.typist == nil {
+			err := pc.session().ShowTyping()
+			if err != nil {
+				logger.Print(err)
+			}
 		}
 
-		reader := bufio.NewReader(os.Stdin)
 		text, err := reader.ReadString('\n')
 		if err != nil {
-			err = o.Disconnect()
+			// The main loop starts a new session once this one is closed
+			err = pc.session().Close()
 			if err != nil {
-				logger.Fatal(err)
+				logger.Print(err)
 			}
-			fmt.Println("- Disconnected")
-			ret := o.GetID()
-			if ret != nil {
-				logger.Fatal(ret)
+			pc.println("- Disconnected")
+			continue
+		}
+
+		if st.typist == nil {
+			err = pc.session().StopTyping()
+			if err != nil {
+				logger.Print(err)
 			}
+		}
+
+		if strings.HasPrefix(text, "/") && !strings.HasPrefix(text, "//") {
+			runCommand(pc, st, strings.TrimSpace(text))
 			continue
 		}
+		text = strings.TrimPrefix(text, "/")
 
-		err = o.StopTyping()
+		if st.typist != nil {
+			err = st.typist.Send(context.Background(), pc.session(), text)
+		} else {
+			err = pc.session().SendMessage(text)
+		}
 		if err != nil {
 			logger.Print(err)
+			continue
 		}
+	}
+}
 
-		err = o.SendMessage(text)
-		if err != nil {
-			logger.Fatal(err)
-			continue
+// eventLine describes ev in a line of text, "" if it has nothing to show
+func eventLine(ev gomegle.EventData) string {
+	switch ev := ev.(type) {
+	case gomegle.StatusInfoEvent:
+		return fmt.Sprintf("%% Got server event. Count: %v; Force_unmon: %v; SpyQueueTime: %v; SpyeeQueueTime: %v",
+			ev.Status.Count, ev.Status.ForceUnmon, ev.Status.SpyQueueTime, ev.Status.SpyeeQueueTime)
+	case gomegle.QuestionEvent:
+		return fmt.Sprintf("> Question: %s", ev.Text)
+	case gomegle.SpyTypingEvent:
+		return fmt.Sprintf("> %s is typing", ev.Who)
+	case gomegle.SpyStoppedTypingEvent:
+		return fmt.Sprintf("> %s stopped typing", ev.Who)
+	case gomegle.SpyDisconnectedEvent:
+		return fmt.Sprintf("> %s disconnected", ev.Who)
+	case gomegle.SpyMessageEvent:
+		return fmt.Sprintf("%s: %s", ev.Who, ev.Text)
+	case gomegle.MessageEvent:
+		return ev.Text
+	case gomegle.ErrorEvent:
+		return fmt.Sprintf("- Error: %s", ev.Text)
+	case gomegle.ServerMessageEvent:
+		return fmt.Sprintf("%% %s", ev.Text)
+	case gomegle.RecaptchaRequiredEvent:
+		return fmt.Sprintf("%% You need to go to the omegle website to enter a reCAPTCHA (%s)", ev.Challenge)
+	case gomegle.RecaptchaRejectedEvent:
+		return fmt.Sprintf("%% The reCAPTCHA was rejected (%s)", ev.Challenge)
+	case gomegle.PartnerCollegeEvent:
+		return fmt.Sprintf("%% Partner college: %s", ev.College)
+	case gomegle.CommonLikesEvent:
+		return fmt.Sprintf("%% Shared topics: %s", strings.Join(ev.Topics, " "))
+	case gomegle.Event:
+		switch ev {
+		case gomegle.WAITING:
+			return "> Waiting..."
+		case gomegle.CONNECTED:
+			return "+ Connected"
+		case gomegle.DISCONNECTED:
+			return "- Disconnected"
+		case gomegle.TYPING:
+			return "> Stranger is typing"
+		case gomegle.STOPPEDTYPING:
+			return "> Stranger stopped typing"
+		case gomegle.CONNECTIONDIED:
+			return "- Error occured, disconnected"
+		}
+	}
+	return ""
+}
+
+// printEvent shows ev to the user. asl is sent as soon as a stranger connects.
+// Returns true if we have been banned and the client has to exit
+func printEvent(pc *plainChat, s *gomegle.Session, ev gomegle.EventData, asl string, logger *log.Logger) bool {
+	switch ev := ev.(type) {
+	case gomegle.Event:
+		switch ev {
+		case gomegle.ANTINUDEBANNED:
+			fmt.Printf("%% You have been banned for possible bad behaviour!\n")
+			fmt.Printf("%% Pass -group=\"unmon\" to join unmonitored chat\n")
+			return true
+		case gomegle.CONNECTED:
+			if srv := s.Server(); srv != "" {
+				pc.println(fmt.Sprintf("+ Connected (%s)", srv))
+			} else {
+				pc.println("+ Connected")
+			}
+			if asl != "" {
+				err := s.SendMessage(asl)
+				pc.println("+ Sent ASL")
+				if err != nil {
+					logger.Print(err)
+				}
+			}
+			return false
 		}
 	}
+	if line := eventLine(ev); line != "" {
+		pc.println(line)
+	}
+	return false
+}
+
+// pendingMessage is a message waiting for the operator's decision
+type pendingMessage struct {
+	m     gomegle.RelayMessage
+	reply chan string // Receives the line the operator answered with
+}
+
+// operator lets the user decide what happens to relayed messages and inject
+// new ones. All input is read from stdin by run
+type operator struct {
+	r        *gomegle.Relay
+	requests chan pendingMessage
+	out      sync.Mutex // Keeps lines from different goroutines apart
+}
+
+// Print a line of output
+func (op *operator) println(a ...interface{}) {
+	defer op.out.Unlock()
+	op.out.Lock()
+	fmt.Println(a...)
+}
+
+// Intercept waits until the operator decides what to do with m
+func (op *operator) Intercept(ctx context.Context, m gomegle.RelayMessage) (string, bool) {
+	p := pendingMessage{m, make(chan string, 1)}
+	select {
+	case op.requests <- p:
+	case <-ctx.Done():
+		return "", false
+	}
+
+	var line string
+	select {
+	case line = <-p.reply:
+	case <-ctx.Done():
+		return "", false
+	}
+	switch {
+	case line == "d":
+		return "", false
+	case strings.HasPrefix(line, "e "):
+		return line[2:], true
+	}
+	return m.Text, true
+}
+
+// Show the message waiting for a decision
+func (op *operator) prompt(queue []pendingMessage) {
+	m := queue[0].m
+	op.println(fmt.Sprintf("? %v -> %v: %s", m.From, m.From.Other(), m.Text))
+	op.println(fmt.Sprintf("? [enter] pass, [d] drop, [e text] edit (%d pending)", len(queue)))
+}
+
+// run reads the operator's commands from stdin until ctx is done. Lines
+// starting with "1 " or "2 " are sent to that stranger, other lines answer
+// the oldest pending message
+func (op *operator) run(ctx context.Context) {
+	lines := make(chan string)
+	go func() {
+		in := bufio.NewScanner(os.Stdin)
+		for in.Scan() {
+			lines <- in.Text()
+		}
+		close(lines)
+	}()
+
+	var queue []pendingMessage
+	for {
+		select {
+		case p := <-op.requests:
+			queue = append(queue, p)
+			if len(queue) == 1 {
+				op.prompt(queue)
+			}
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if strings.HasPrefix(line, "1 ") || strings.HasPrefix(line, "2 ") {
+				to := gomegle.SideA
+				if line[0] == '2' {
+					to = gomegle.SideB
+				}
+				if err := op.r.Inject(ctx, to, line[2:]); err != nil {
+					op.println("! " + err.Error())
+				}
+				continue
+			}
+			if len(queue) == 0 {
+				op.println("! Nothing to decide on, use \"1 text\" or \"2 text\" to talk to a stranger")
+				continue
+			}
+			queue[0].reply <- line
+			queue = queue[1:]
+			if len(queue) != 0 {
+				op.prompt(queue)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runRelay bridges two strangers and prints everything they do until ctx is
+// done, finding a new stranger whenever one of them leaves. The user can talk
+// to either stranger and, with intercept, every message waits for the user to
+// pass, edit or drop it
+func runRelay(ctx context.Context, o *gomegle.Omegle, intercept bool, logger *log.Logger) {
+	op := &operator{requests: make(chan pendingMessage)}
+	r := &gomegle.Relay{
+		A:       o,
+		Rematch: true,
+		OnEntry: func(e gomegle.RelayEntry) {
+			op.println(fmt.Sprintf("[%s] %v", e.Time.Format("15:04:05"), e))
+		},
+	}
+	op.r = r
+	if intercept {
+		r.Interceptor = op
+	}
+	go op.run(ctx)
+	err := r.Run(ctx)
+	if err != nil && ctx.Err() == nil {
+		logger.Fatal(err)
+	}
+	fmt.Println("- Relay stopped")
+}
+
+// Describe the topics searched for after a step of the topic fallback
+func fallbackLine(topics []string) string {
+	if len(topics) == 0 {
+		return "% Nobody shares your topics, looking for any stranger"
+	}
+	return "% Nobody shares your topics, looking for strangers interested in: " + strings.Join(topics, ", ")
+}
+
+// watchStatus appends every new status sample of w to f until ctx is done
+func watchStatus(ctx context.Context, w *gomegle.StatusWatcher, f *os.File) {
+	info, err := f.Stat()
+	header := err == nil && info.Size() == 0
+	samples, cancel := w.Subscribe()
+	defer cancel()
+	go w.Run(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-samples:
+			if gomegle.WriteStatusCSV(f, []gomegle.StatusSample{s}, header) == nil {
+				header = false
+			}
+		}
+	}
+}
+
+// Parse the URL given with -endpoint. Servers picked with -server are put in
+// front of its host name unless the URL ends in /{server}, which cannot work
+// for IP addresses
+func parseEndpoint(rawurl, server string) (gomegle.Endpoint, error) {
+	e, err := gomegle.ParseEndpoint(rawurl)
+	if err != nil {
+		return e, err
+	}
+	if server != "" && !e.ServerInPath && (net.ParseIP(e.Host) != nil || e.Host == "localhost") {
+		return e, fmt.Errorf("%s cannot have servers in front of it, add /{server} to the end of -endpoint", e.Host)
+	}
+	return e, nil
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "search" {
+		runSearch(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "script" {
+		runScript(os.Args[2:])
+		return
+	}
+
 	var o gomegle.Omegle
 	lang := flag.String("lang", "", "Two character language code for searching strangers that only speak that language")
 	group := flag.String("group", "", "Only search for strangers in this group (\"unmon\" for unmonitored chat)")
-	server := flag.String("server", "", "Connect to this server to search for strangers")
+	server := flag.String("server", "", "Connect to this server to search for strangers, \"auto\" to pick one from the server list and fail over to the others")
+	strategy := flag.String("strategy", "random", "How to pick a server with -server=auto (random, roundrobin or latency)")
 	question := flag.String("question", "", "If not empty then turn on \"spyer\" mode and use this question")
 	topics := flag.String("topic", "", "A comma delimited list of topics you are interested in")
 	cansavequestion := flag.Bool("cansavequestion", false, "If true then in \"spyer\" mode omegle will be permitted to re-use your question")
@@ -59,11 +402,50 @@ func main() {
 	collegeAuth := flag.String("collegeauth", "", "If not empty then will be used as college authentication code")
 	college := flag.String("college", "", "If not empty then will be used as college authentication name (must match real college name)")
 	anyCollege := flag.Bool("anycollege", false, "If true then in college mode we will try to connect to any college")
+	endpoint := flag.String("endpoint", "", "If not empty then the chat servers are reached at this URL (such as https://omegle.com, or http://127.0.0.1:8080/{server} to put the server into the path)")
+	logEndpoint := flag.String("logendpoint", "", "If not empty then the log server is reached at this URL (such as https://logs.omegle.com)")
+	topicTimeout := flag.Duration("topic-timeout", 0, "If not 0 then drop the topics one by one, and then look for any stranger, when nobody connects for this long")
+	retries := flag.Int("retries", 5, "How many times in a row to try to reconnect after a failure, 0 for no limit")
+	relay := flag.Bool("relay", false, "If true then two strangers are connected to each other and you watch them talk")
+	intercept := flag.Bool("intercept", false, "If true then in relay mode every message waits for you to pass, edit or drop it")
+	typingWPM := flag.Float64("typing-wpm", 0, "If not 0 then in -plain mode messages are typed at this many words per minute before they are sent")
+	plain := flag.Bool("plain", false, "If true then print events line by line instead of using the full-screen interface")
+	archivePath := flag.String("archive", "", "If not empty then every conversation is stored in this file, search it with the search subcommand")
+	statusCSV := flag.String("status-csv", "", "If not empty then the status of omegle is appended to this CSV file")
+	statusInterval := flag.Duration("status-interval", gomegle.DefaultStatusInterval, "How often to fetch the status with -status-csv")
+	saveFormat := flag.String("save-format", "text", "Format of the files written by /save (text, markdown, html, jsonl, png or svg)")
 	flag.Parse()
 
 	logger := log.New(os.Stderr, "", log.LstdFlags)
 
-	if *server != "" {
+	if *endpoint != "" {
+		e, err := parseEndpoint(*endpoint, *server)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		o.Endpoint = e
+	}
+	if *logEndpoint != "" {
+		e, err := gomegle.ParseEndpoint(*logEndpoint)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		o.LogEndpoint = e
+	}
+
+	if *server == "auto" {
+		o.Pool = &gomegle.ServerPool{}
+		switch *strategy {
+		case "random":
+			o.Pool.Strategy = gomegle.Random
+		case "roundrobin":
+			o.Pool.Strategy = gomegle.RoundRobin
+		case "latency":
+			o.Pool.Strategy = gomegle.LowestLatency
+		default:
+			logger.Fatalf("unknown strategy %q", *strategy)
+		}
+	} else if *server != "" {
 		o.Server = *server
 	}
 
@@ -78,104 +460,124 @@ func main() {
 	if *topics != "" {
 		o.Topics = strings.Split(*topics, ",")
 	}
+	if *topicTimeout != 0 {
+		o.TopicFallback = &gomegle.TopicFallback{
+			Timeout:    *topicTimeout,
+			Broaden:    true,
+			OnFallback: func(topics []string) { fmt.Println(fallbackLine(topics)) },
+		}
+	}
 
-	ret := o.GetID()
-	if ret != nil {
-		logger.Fatal(ret)
+	// Disconnect cleanly on ^C instead of leaving the stranger hanging
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if _, ok := saveFormats[*saveFormat]; !ok {
+		logger.Fatalf("unknown save format %q", *saveFormat)
+	}
+	o.Record = true
+	st := &settings{
+		o:          &o,
+		saveFormat: *saveFormat,
+		archive:    *archivePath,
+		asl:        *asl,
+		retry:      &gomegle.ReconnectPolicy{MaxAttempts: *retries, Jitter: 0.2},
+	}
+	if *typingWPM != 0 {
+		st.typist = &gomegle.TypingSimulator{WPM: *typingWPM, Jitter: 0.3, PauseChance: 0.05}
 	}
-	go messageListener(&o, logger)
 
-	for {
-		st, msg, err := o.UpdateEvents()
+	if *statusCSV != "" {
+		f, err := os.OpenFile(*statusCSV, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
 			logger.Fatal(err)
 		}
+		defer f.Close()
+		w := &gomegle.StatusWatcher{Omegle: st.snapshot(), Interval: *statusInterval}
+		go watchStatus(ctx, w, f)
+	}
 
-		for i := range st {
-			num, okEvent := st[i].(gomegle.Event)
-			status, okStatus := st[i].(gomegle.Status)
+	if *relay || *intercept {
+		runRelay(ctx, &o, *intercept, logger)
+		return
+	}
 
-			if okStatus {
-				fmt.Printf("%% Got server event. Count: %v; Force_unmon: %v; SpyQueueTime: %v; SpyeeQueueTime: %v\n",
-					status.Count, status.ForceUnmon, status.SpyQueueTime, status.SpyeeQueueTime)
-				continue
-			}
+	if !*plain {
+		err := termbox.Init()
+		if err == nil {
+			runTUI(ctx, st)
+			termbox.Close()
+			return
+		}
+		logger.Print("Falling back to plain output: ", err)
+	}
 
-			if !okEvent {
-				continue
+	var cur current
+	pc := &plainChat{cur: &cur, stop: stop}
+	go func() {
+		<-ctx.Done()
+		if s := cur.get(); s != nil {
+			s.Close()
+		}
+	}()
+
+	// Every stranger gets a session of their own started with the settings
+	// of the moment, failures counts the sessions that failed in a row
+	failures := 0
+	for {
+		conf := st.snapshot()
+		s, err := conf.Start(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			if cur.get() == nil {
+				cur.set(s)
+				go messageListener(pc, st, logger)
 			}
+			cur.set(s)
 
-			switch num {
-			case gomegle.ANTINUDEBANNED:
-				fmt.Printf("%% You have been banned for possible bad behaviour!\n")
-				fmt.Printf("%% Pass -group=\"unmon\" to join unmonitored chat\n")
-				os.Exit(1)
-				return
-			case gomegle.WAITING:
-				fmt.Println("> Waiting...")
-			case gomegle.CONNECTED:
-				fmt.Println("+ Connected")
-				if *asl != "" && *question == "" && *wantsspy == false {
-					err = o.SendMessage(*asl)
-					fmt.Println("+ Sent ASL")
-					if err != nil {
-						logger.Print(err)
-					}
-				}
-			case gomegle.DISCONNECTED:
-				fmt.Println("- Disconnected")
-				ret := o.GetID()
-				if ret != nil {
-					logger.Fatal(ret)
-				}
-			case gomegle.TYPING:
-				fmt.Println("> Stranger is typing")
-			case gomegle.QUESTION:
-				fmt.Printf("> Question: %s\n", msg[i][0])
-			case gomegle.SPYTYPING:
-				fmt.Printf("> %s is typing\n", msg[i][0])
-			case gomegle.SPYSTOPPEDTYPING:
-				fmt.Printf("> %s stopped typing\n", msg[i][0])
-			case gomegle.SPYDISCONNECTED:
-				fmt.Printf("> %s disconnected\n", msg[i][0])
-				ret := o.GetID()
-				if ret != nil {
-					logger.Fatal(ret)
+			ar := &archiver{st: st, conf: conf, s: s}
+			banned := false
+			for ev := range s.Events() {
+				if ev.Type() == gomegle.CONNECTED {
+					failures = 0
+					cur.connected()
 				}
-			case gomegle.SPYMESSAGE:
-				fmt.Printf("%s: %s\n", msg[i][0], msg[i][1])
-			case gomegle.MESSAGE:
-				fmt.Printf("%s\n", msg[i][0])
-			case gomegle.STOPPEDTYPING:
-				fmt.Println("> Stranger stopped typing")
-			case gomegle.CONNECTIONDIED:
-				fmt.Println("- Error occured, disconnected")
-				ret := o.GetID()
-				if ret != nil {
-					logger.Fatal(ret)
+				if banned = printEvent(pc, s, ev, st.greeting(conf), logger); banned {
+					break
 				}
-			case gomegle.ERROR:
-				fmt.Printf("- Error: %s (sleeping 500ms)\n", msg[i][0])
-				time.Sleep(500 * time.Millisecond)
-				ret := o.GetID()
-				if ret != nil {
-					logger.Fatal(ret)
-				}
-			case gomegle.SERVERMESSAGE:
-				fmt.Printf("%% %s\n", msg[i][0])
-			case gomegle.RECAPTCHAREQUIRED:
-				fmt.Printf("%% You need to go to the omegle website to enter a reCAPTCHA (%s)\n", msg[i][0])
-			case gomegle.RECAPTCHAREJECTED:
-				fmt.Printf("%% The reCAPTCHA was rejected (%s)\n", msg[i][0])
-			case gomegle.PARTNERCOLLEGE:
-				fmt.Printf("%% Partner college: %s\n", msg[i][0])
-			case gomegle.COMMONLIKES:
-				fmt.Printf("%% Shared topics:")
-				for j := range msg[i] {
-					fmt.Printf(" %s", msg[i][j])
+				if point, over := archivePoint(ev); point {
+					if err := ar.flush(over); err != nil {
+						logger.Print(err)
+					}
 				}
-				fmt.Printf("\n")
 			}
+			s.Close()
+			if err := ar.flush(true); err != nil {
+				logger.Print(err)
+			}
+			if banned {
+				os.Exit(1)
+			}
+			if ctx.Err() != nil {
+				pc.println("- Disconnected")
+				return
+			}
+			if err = s.Err(); err == nil {
+				continue // The stranger left or the user moved on
+			}
+		}
+
+		failures++
+		delay, ok := st.backoff(failures)
+		if !ok {
+			logger.Fatal(err)
+		}
+		fmt.Printf("%% Reconnecting in %v (%v, attempt %d)\n", delay.Round(time.Millisecond), err, failures)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
 		}
 	}
 }
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GiedriusS/gomegle"
+)
+
+// settings is the configuration used for the next stranger. Commands change
+// it while sessions are started from it in other goroutines
+type settings struct {
+	m          sync.Mutex
+	o          *gomegle.Omegle
+	saveFormat string                   // Format of the files written by /save, never changes
+	archive    string                   // File conversations are archived in, "" if none, never changes
+	typist     *gomegle.TypingSimulator // Types the messages in plain mode, nil to send them at once
+	asl        string                   // Sent as soon as a stranger connects, never changes
+	// How long to wait before starting again after failed sessions, never
+	// changes. Sessions do not reconnect on their own so that every stranger
+	// is found with the settings of the moment
+	retry *gomegle.ReconnectPolicy
+}
+
+// Get a copy of the configuration to start a session with
+func (st *settings) snapshot() *gomegle.Omegle {
+	defer st.m.Unlock()
+	st.m.Lock()
+	return st.o.Config()
+}
+
+// Change the configuration
+func (st *settings) change(f func(o *gomegle.Omegle)) {
+	defer st.m.Unlock()
+	st.m.Lock()
+	f(st.o)
+}
+
+// Get the message to send as soon as a stranger connects to a session
+// started with conf, "" if none. Nobody is talked to directly in spy mode or
+// when asking a question
+func (st *settings) greeting(conf *gomegle.Omegle) string {
+	if conf.Question != "" || conf.Wantsspy {
+		return ""
+	}
+	return st.asl
+}
+
+// Get how long to wait before starting a session again after failures
+// failed sessions in a row, false if we should give up
+func (st *settings) backoff(failures int) (time.Duration, bool) {
+	if st.retry.MaxAttempts != 0 && failures > st.retry.MaxAttempts {
+		return 0, false
+	}
+	return st.retry.Backoff(failures), true
+}
+
+// chat is the conversation slash commands act on. Its methods may be called
+// from any goroutine
+type chat interface {
+	println(line string)
+	session() *gomegle.Session // nil if not talking to anyone
+	next()                     // Leave the stranger and find a new one
+	quit()
+	transcript() *gomegle.Transcript // Of the last session, nil if none
+}
+
+// command is a slash command typed instead of a message
+type command struct {
+	args string // Shown in /help
+	help string
+	run  func(c chat, st *settings, args string) error
+}
+
+// commands by their name, filled in init to avoid an initialisation loop
+// through /help
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"help":        {"", "Show this list", cmdHelp},
+		"next":        {"", "Leave the stranger and find a new one", cmdNext},
+		"quit":        {"", "Disconnect and exit", cmdQuit},
+		"topics":      {"[a,b,...]", "Look for strangers interested in these topics, none if empty", cmdTopics},
+		"lang":        {"[xx]", "Look for strangers speaking this language, any if empty", cmdLang},
+		"mode":        {"spy|ask <question>|text", "Be a spyee, ask a question as a spy or just chat", cmdMode},
+		"stoplooking": {"", "Stop looking for strangers with common topics", cmdStopLooking},
+		"status":      {"", "Show the status of omegle", cmdStatus},
+		"save":        {"[file]", "Write the conversation to a file", cmdSave},
+		"captcha":     {"<challenge> <answer>", "Answer a reCAPTCHA", cmdCaptcha},
+	}
+}
+
+// runCommand runs the slash command in line, which starts with "/"
+func runCommand(c chat, st *settings, line string) {
+	name, args := line[1:], ""
+	if i := strings.IndexByte(name, ' '); i >= 0 {
+		name, args = name[:i], strings.TrimSpace(name[i+1:])
+	}
+	cmd, ok := commands[strings.ToLower(name)]
+	if !ok {
+		c.println(fmt.Sprintf("! Unknown command /%s, try /help", name))
+		return
+	}
+	if err := cmd.run(c, st, args); err != nil {
+		c.println("! " + err.Error())
+	}
+}
+
+// The session commands act on, or an error if there is none
+func talking(c chat) (*gomegle.Session, error) {
+	s := c.session()
+	if s == nil {
+		return nil, fmt.Errorf("not talking to anyone")
+	}
+	return s, nil
+}
+
+func cmdHelp(c chat, st *settings, args string) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := commands[name]
+		c.println(fmt.Sprintf("%% /%s %s - %s", name, cmd.args, cmd.help))
+	}
+	c.println("% Start a message with // to send it with a single /")
+	return nil
+}
+
+func cmdNext(c chat, st *settings, args string) error {
+	c.next()
+	return nil
+}
+
+func cmdQuit(c chat, st *settings, args string) error {
+	c.quit()
+	return nil
+}
+
+func cmdTopics(c chat, st *settings, args string) error {
+	var topics []string
+	for _, t := range strings.Split(args, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			topics = append(topics, t)
+		}
+	}
+	st.change(func(o *gomegle.Omegle) { o.Topics = topics })
+	if len(topics) == 0 {
+		c.println("% No topics for the next stranger")
+	} else {
+		c.println("% Topics for the next stranger: " + strings.Join(topics, ", "))
+	}
+	return nil
+}
+
+func cmdLang(c chat, st *settings, args string) error {
+	if args != "" && len(args) != 2 {
+		return fmt.Errorf("the language must be a two character code such as \"en\"")
+	}
+	st.change(func(o *gomegle.Omegle) { o.Lang = args })
+	if args == "" {
+		c.println("% Any language for the next stranger")
+	} else {
+		c.println("% Language for the next stranger: " + args)
+	}
+	return nil
+}
+
+func cmdMode(c chat, st *settings, args string) error {
+	mode, question := args, ""
+	if i := strings.IndexByte(args, ' '); i >= 0 {
+		mode, question = args[:i], strings.TrimSpace(args[i+1:])
+	}
+	switch mode {
+	case "spy":
+		st.change(func(o *gomegle.Omegle) { o.Wantsspy, o.Question = true, "" })
+		c.println("% The next stranger will ask you and another stranger a question")
+	case "ask":
+		if question == "" {
+			return fmt.Errorf("usage: /mode ask <question>")
+		}
+		st.change(func(o *gomegle.Omegle) { o.Wantsspy, o.Question = false, question })
+		c.println("% You will ask the next two strangers: " + question)
+	case "text":
+		st.change(func(o *gomegle.Omegle) { o.Wantsspy, o.Question = false, "" })
+		c.println("% The next stranger will just chat")
+	default:
+		return fmt.Errorf("usage: /mode spy|ask <question>|text")
+	}
+	return nil
+}
+
+func cmdStopLooking(c chat, st *settings, args string) error {
+	s, err := talking(c)
+	if err != nil {
+		return err
+	}
+	if err := s.StopLookingForCommonLikes(); err != nil {
+		return err
+	}
+	c.println("% Looking for any stranger")
+	return nil
+}
+
+func cmdStatus(c chat, st *settings, args string) error {
+	status, err := st.snapshot().GetStatus()
+	if err != nil {
+		return err
+	}
+	c.println(fmt.Sprintf("%% %d online; SpyQueueTime: %v; SpyeeQueueTime: %v; Servers: %s",
+		status.Count, status.SpyQueueTime, status.SpyeeQueueTime, strings.Join(status.Servers, " ")))
+	if status.ForceUnmon {
+		c.println("% You are only allowed in unmonitored chat")
+	}
+	return nil
+}
+
+// saveFormats writes a transcript in each format /save supports, by name
+var saveFormats = map[string]struct {
+	ext   string // File name extension
+	write func(t *gomegle.Transcript, w io.Writer) error
+}{
+	"text":     {".txt", func(t *gomegle.Transcript, w io.Writer) error { return gomegle.WriteText(w, t.LogEntries()) }},
+	"markdown": {".md", func(t *gomegle.Transcript, w io.Writer) error { return gomegle.WriteMarkdown(w, t.LogEntries()) }},
+	"html":     {".html", func(t *gomegle.Transcript, w io.Writer) error { return gomegle.WriteHTML(w, t.LogEntries()) }},
+	"jsonl":    {".jsonl", (*gomegle.Transcript).WriteJSONL},
+	"png":      {".png", func(t *gomegle.Transcript, w io.Writer) error { return gomegle.RenderPNG(w, t.LogEntries()) }},
+	"svg":      {".svg", func(t *gomegle.Transcript, w io.Writer) error { return gomegle.RenderSVG(w, t.LogEntries()) }},
+}
+
+// The conversation /save writes: the last one in which a stranger connected,
+// since the session moves on to a new one as soon as the stranger leaves
+func savedConversation(t *gomegle.Transcript) *gomegle.Transcript {
+	convs := t.Conversations()
+	for i := len(convs) - 1; i >= 0; i-- {
+		for _, r := range convs[i].Records() {
+			if r.Event == gomegle.CONNECTED {
+				return convs[i]
+			}
+		}
+	}
+	return nil
+}
+
+func cmdSave(c chat, st *settings, args string) error {
+	var t *gomegle.Transcript
+	if all := c.transcript(); all != nil {
+		t = savedConversation(all)
+	}
+	if t == nil {
+		return fmt.Errorf("nothing to save yet")
+	}
+	format := saveFormats[st.saveFormat]
+	name := args
+	if name == "" {
+		name = time.Now().Format("gomegle-20060102-150405") + format.ext
+	}
+
+	var buf bytes.Buffer
+	if err := format.write(t, &buf); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	c.println(fmt.Sprintf("%% Saved the conversation to %s", name))
+	return nil
+}
+
+func cmdCaptcha(c chat, st *settings, args string) error {
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		return fmt.Errorf("usage: /captcha <challenge> <answer>")
+	}
+	s, err := talking(c)
+	if err != nil {
+		return err
+	}
+	return s.Recaptcha(fields[0], strings.Join(fields[1:], " "))
+}
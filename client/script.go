@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/GiedriusS/gomegle"
+	"github.com/GiedriusS/gomegle/bot"
+	"github.com/GiedriusS/gomegle/script"
+)
+
+// runScript runs the script subcommand with its arguments
+func runScript(args []string) {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	fs := flag.NewFlagSet("script", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s script [flags] file.json\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	lang := fs.String("lang", "", "Two character language code for searching strangers that only speak that language")
+	topics := fs.String("topic", "", "A comma delimited list of topics you are interested in")
+	server := fs.String("server", "", "Connect to this server to search for strangers")
+	endpoint := fs.String("endpoint", "", "If not empty then the chat servers are reached at this URL (such as https://omegle.com, or http://127.0.0.1:8080/{server} to put the server into the path)")
+	rematch := fs.Bool("rematch", false, "If true then the script is run again with a new stranger whenever a conversation ends")
+	typingWPM := fs.Float64("typing-wpm", 0, "If not 0 then messages are typed at this many words per minute before they are sent")
+	archivePath := fs.String("archive", "", "If not empty then every conversation is stored in this file, search it with the search subcommand")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	sc, err := script.Load(fs.Arg(0))
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	o := &gomegle.Omegle{Lang: *lang, Server: *server, Record: *archivePath != ""}
+	if *endpoint != "" {
+		e, err := parseEndpoint(*endpoint, *server)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		o.Endpoint = e
+	}
+	if *topics != "" {
+		o.Topics = strings.Split(*topics, ",")
+	}
+
+	st := &settings{o: o, archive: *archivePath}
+	b := &bot.Bot{
+		Omegle:  o,
+		Rematch: *rematch,
+		Outbound: []bot.Middleware{func(c *bot.Conversation, text string) (string, bool) {
+			fmt.Println("You: " + text)
+			return text, true
+		}},
+	}
+	if *typingWPM != 0 {
+		b.Typing = &gomegle.TypingSimulator{WPM: *typingWPM, Jitter: 0.3, PauseChance: 0.05}
+	}
+	b.OnConnect(func(c *bot.Conversation) { fmt.Println("+ Connected") })
+	b.OnCommonLikes(func(c *bot.Conversation, topics []string) {
+		fmt.Println(eventLine(gomegle.CommonLikesEvent{Topics: topics}))
+	})
+	b.OnMessage(func(c *bot.Conversation, text string) { fmt.Println("Stranger: " + text) })
+	b.OnDisconnect(func(c *bot.Conversation) {
+		fmt.Println("- Disconnected")
+		if err := st.archiveSession(o, c.Session()); err != nil {
+			logger.Print(err)
+		}
+	})
+	r := &script.Runner{
+		Script:  sc,
+		OnEnter: func(c *bot.Conversation, state string) { fmt.Printf("%% Script state: %s\n", state) },
+		OnError: func(c *bot.Conversation, err error) { logger.Print(err) },
+	}
+	r.Handle(&b.Router)
+
+	// Disconnect cleanly on ^C instead of leaving the stranger hanging
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := b.Run(ctx); err != nil && ctx.Err() == nil {
+		logger.Fatal(err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/GiedriusS/gomegle"
+	"github.com/GiedriusS/gomegle/archive"
+)
+
+// archiver stores the conversations of a session in the archive, if there
+// is one, as soon as they end instead of when the session does, so that they
+// are not lost if the client dies while the session goes on with new
+// strangers. Its methods must not be called concurrently
+type archiver struct {
+	st   *settings
+	conf *gomegle.Omegle // What the session was started with
+	s    *gomegle.Session
+	done int // How many conversations of s were archived
+}
+
+// Whether ev is a point at which the archiver should be flushed, and whether
+// the current conversation is over by then
+func archivePoint(ev gomegle.EventData) (point, over bool) {
+	e, _ := ev.(gomegle.Event)
+	switch e {
+	case gomegle.DISCONNECTED, gomegle.CONNECTIONDIED:
+		return true, true
+	case gomegle.WAITING:
+		// A new conversation started, so the one before it is over
+		return true, false
+	}
+	return false, false
+}
+
+// Archive the conversations that ended since the last flush. The current
+// conversation is only archived if over is true. The archive is only open
+// while writing so that it can be searched while the client is running
+func (a *archiver) flush(over bool) error {
+	if a.st.archive == "" || a.s.Transcript() == nil {
+		return nil
+	}
+	parts := a.s.Transcript().Conversations()
+	n := len(parts)
+	if !over {
+		n--
+	}
+	if n <= a.done {
+		return nil
+	}
+	var convs []*archive.Conversation
+	for _, part := range parts[a.done:n] {
+		convs = append(convs, archive.FromTranscript(a.conf, part)...)
+	}
+	a.done = n
+	if len(convs) == 0 {
+		return nil
+	}
+
+	arc, err := archive.Open(a.st.archive)
+	if err != nil {
+		return fmt.Errorf("archiving the conversation: %w", err)
+	}
+	defer arc.Close()
+	for _, c := range convs {
+		if err := arc.Add(c); err != nil {
+			return fmt.Errorf("archiving the conversation: %w", err)
+		}
+	}
+	return nil
+}
+
+// Store all conversations of a session started with conf in the archive
+func (st *settings) archiveSession(conf *gomegle.Omegle, s *gomegle.Session) error {
+	return (&archiver{st: st, conf: conf, s: s}).flush(true)
+}
+
+// Parse a date such as 2014-06-01 in the local time zone
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// Print a conversation found by search
+func printConversation(c *archive.Conversation) {
+	fmt.Printf("#%d %s %s %v, %s\n", c.ID, c.Start.Local().Format("2006-01-02 15:04:05"), c.Mode,
+		c.Duration().Round(time.Second), c.Reason)
+	var about []string
+	if len(c.Topics) != 0 {
+		about = append(about, "topics: "+strings.Join(c.Topics, ", "))
+	}
+	if len(c.CommonLikes) != 0 {
+		about = append(about, "common likes: "+strings.Join(c.CommonLikes, ", "))
+	}
+	if c.College != "" {
+		about = append(about, "college: "+c.College)
+	}
+	if len(about) != 0 {
+		fmt.Printf("  %s\n", strings.Join(about, "; "))
+	}
+	if c.Question != "" {
+		fmt.Printf("  Question: %s\n", c.Question)
+	}
+	for _, m := range c.Messages {
+		fmt.Printf("  [%s] %s: %s\n", m.Time.Local().Format("15:04:05"), m.From, m.Text)
+	}
+	fmt.Println()
+}
+
+// runSearch runs the search subcommand with its arguments
+func runSearch(args []string) {
+	logger := log.New(os.Stderr, "", 0)
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s search -archive file [flags] [words...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	path := fs.String("archive", "", "The archive to search, written by the client with -archive")
+	from := fs.String("from", "", "If not empty then only conversations started on this day (2006-01-02) or later")
+	to := fs.String("to", "", "If not empty then only conversations started on this day (2006-01-02) or earlier")
+	topics := fs.String("topic", "", "A comma delimited list of topics the conversations must have among their topics or common likes")
+	limit := fs.Int("limit", 0, "If not 0 then only show this many of the latest conversations")
+	fs.Parse(args)
+
+	if *path == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	q := archive.Query{Text: strings.Join(fs.Args(), " "), Limit: *limit}
+	var err error
+	if q.From, err = parseDate(*from); err != nil {
+		logger.Fatal(err)
+	}
+	if q.To, err = parseDate(*to); err != nil {
+		logger.Fatal(err)
+	}
+	if !q.To.IsZero() {
+		q.To = q.To.AddDate(0, 0, 1)
+	}
+	if *topics != "" {
+		q.Topics = strings.Split(*topics, ",")
+	}
+
+	a, err := archive.Open(*path)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer a.Close()
+	convs, err := a.Search(q)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	for _, c := range convs {
+		printConversation(c)
+	}
+	fmt.Printf("%d conversations found\n", len(convs))
+}
@@ -0,0 +1,604 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GiedriusS/gomegle"
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+// How many lines of scrollback are kept
+const maxScrollback = 1000
+
+// startResult is what starting a session in the background ended with
+type startResult struct {
+	s    *gomegle.Session
+	conf *gomegle.Omegle // What s was started with
+	err  error
+}
+
+// chatUI is the full-screen interface for talking to strangers. Everything
+// but the network requests happens in the goroutine running run
+type chatUI struct {
+	ctx      context.Context
+	st       *settings
+	asl      string // Sent as soon as the stranger of s connects
+	quit     bool
+	calls    chan func()   // Run by run, used by commands to reach the interface
+	stopped  chan struct{} // Closed when run returns
+	starts   chan startResult
+	errs     chan error        // Errors of the requests made by worker
+	work     chan func() error // Requests to the server, made in order by worker
+	fallback chan []string     // Topics searched for after the topic fallback
+
+	s        *gomegle.Session    // Current session, nil if not talking to anyone
+	starting bool                // Whether a session is being started
+	ar       *archiver           // Archives the conversations of s
+	recorded *gomegle.Transcript // Transcript of the last session a stranger connected in
+	failures int                 // Sessions that failed in a row
+	retryAt  <-chan time.Time    // When to start again after a failure, nil if not waiting
+
+	lines  []string // Scrollback
+	scroll int      // How many rows the scrollback is scrolled up
+	input  []rune
+	cursor int  // Position of the cursor in input
+	typing bool // Whether the stranger was told that we are typing
+
+	state   string   // Such as "Waiting" or "Connected"
+	likes   []string // Topics shared with the stranger
+	college string   // College of the stranger
+	count   int      // People online, 0 if not known
+	who     string   // Who is typing, "" if nobody
+}
+
+// runTUI talks to strangers in a full-screen interface until ctx is done or
+// the user quits. The terminal must have been initialised by the caller
+func runTUI(ctx context.Context, st *settings) {
+	ui := &chatUI{
+		ctx:      ctx,
+		st:       st,
+		calls:    make(chan func(), 16),
+		stopped:  make(chan struct{}),
+		starts:   make(chan startResult),
+		errs:     make(chan error, 16),
+		work:     make(chan func() error, 64),
+		fallback: make(chan []string, 16),
+	}
+	st.change(func(o *gomegle.Omegle) {
+		if o.TopicFallback == nil {
+			return
+		}
+		o.TopicFallback = &gomegle.TopicFallback{
+			Timeout: o.TopicFallback.Timeout,
+			Broaden: o.TopicFallback.Broaden,
+			OnFallback: func(topics []string) {
+				select {
+				case ui.fallback <- topics:
+				default:
+				}
+			},
+		}
+	})
+	done := make(chan struct{})
+	go ui.worker(done)
+	ui.run()
+	close(ui.work)
+	<-done
+}
+
+// worker makes the requests queued in work one after another until work is
+// closed
+func (ui *chatUI) worker(done chan<- struct{}) {
+	defer close(done)
+	for f := range ui.work {
+		if err := f(); err != nil && ui.ctx.Err() == nil {
+			select {
+			case ui.errs <- err:
+			default:
+			}
+		}
+	}
+}
+
+// Queue a request for the current session
+func (ui *chatUI) do(f func(s *gomegle.Session) error) {
+	s := ui.s
+	if s == nil {
+		return
+	}
+	select {
+	case ui.work <- func() error { return f(s) }:
+	default:
+		ui.println("! Too many requests in flight, dropped one")
+	}
+}
+
+// run handles keys and events until the user quits
+func (ui *chatUI) run() {
+	keys := make(chan termbox.Event)
+	go func() {
+		for {
+			ev := termbox.PollEvent()
+			if ev.Type == termbox.EventInterrupt {
+				return
+			}
+			keys <- ev
+		}
+	}()
+	defer termbox.Interrupt()
+	defer close(ui.stopped)
+
+	ui.next()
+	for !ui.quit {
+		ui.draw()
+
+		var events <-chan gomegle.EventData
+		if ui.s != nil {
+			events = ui.s.Events()
+		}
+		select {
+		case ev := <-keys:
+			ui.handleKey(ev)
+		case ev, ok := <-events:
+			if !ok {
+				ui.ended()
+				continue
+			}
+			ar := ui.ar
+			ui.handleEvent(ev)
+			if point, over := archivePoint(ev); point {
+				ui.work <- func() error { return ar.flush(over) }
+			}
+		case r := <-ui.starts:
+			ui.started(r)
+		case f := <-ui.calls:
+			f()
+		case err := <-ui.errs:
+			ui.println("! " + err.Error())
+		case <-ui.retryAt:
+			ui.next()
+		case topics := <-ui.fallback:
+			ui.println(fallbackLine(topics))
+		case <-ui.ctx.Done():
+			ui.quit = true
+		}
+	}
+
+	ui.disconnect()
+	for ui.starting {
+		ui.started(<-ui.starts)
+	}
+}
+
+// Add a line to the scrollback
+func (ui *chatUI) println(line string) {
+	ui.lines = append(ui.lines, line)
+	if len(ui.lines) > maxScrollback {
+		ui.lines = ui.lines[len(ui.lines)-maxScrollback:]
+	}
+}
+
+// Disconnect from the current stranger, if any
+func (ui *chatUI) disconnect() {
+	if ui.s == nil {
+		return
+	}
+	s := ui.s
+	ui.s = nil
+	ui.typing = false
+	ui.who = ""
+	ui.state = "Disconnected"
+	ar := ui.ar
+	ui.work <- func() error {
+		err := s.Close()
+		if aerr := ar.flush(true); aerr != nil {
+			return aerr
+		}
+		return err
+	}
+}
+
+// Leave the current stranger and look for a new one
+func (ui *chatUI) next() {
+	ui.disconnect()
+	ui.retryAt = nil
+	if ui.starting {
+		return
+	}
+	ui.starting = true
+	ui.state = "Starting"
+	ui.likes, ui.college = nil, ""
+	conf := ui.st.snapshot()
+	go func() {
+		s, err := conf.Start(ui.ctx)
+		ui.starts <- startResult{s, conf, err}
+	}()
+}
+
+// Handle the end of a session being started
+func (ui *chatUI) started(r startResult) {
+	ui.starting = false
+	if r.err != nil {
+		ui.state = "Disconnected"
+		if !ui.quit && ui.ctx.Err() == nil {
+			ui.failed(r.err)
+		}
+		return
+	}
+	if ui.quit {
+		r.s.Close()
+		return
+	}
+	ui.s, ui.ar = r.s, &archiver{st: ui.st, conf: r.conf, s: r.s}
+	ui.asl = ui.st.greeting(r.conf)
+}
+
+// Handle the end of the current session. Sessions do not reconnect on their
+// own, so a new one is started with the settings of the moment
+func (ui *chatUI) ended() {
+	err := ui.s.Err()
+	ar := ui.ar
+	ui.s.Close()
+	ui.work <- func() error { return ar.flush(true) }
+	ui.s = nil
+	ui.who = ""
+	ui.state = "Disconnected"
+	if err != nil {
+		ui.failed(err)
+		return
+	}
+	ui.next()
+}
+
+// Handle a session that failed to start or ended with an error by starting
+// again after a while, unless it failed too many times in a row
+func (ui *chatUI) failed(err error) {
+	ui.println("! " + err.Error())
+	ui.failures++
+	delay, ok := ui.st.backoff(ui.failures)
+	if !ok {
+		ui.println("% Press Ctrl-N to find a new stranger")
+		return
+	}
+	ui.println(fmt.Sprintf("%% Reconnecting in %v (attempt %d)", delay.Round(time.Millisecond), ui.failures))
+	ui.retryAt = time.After(delay)
+}
+
+// Handle an event of the current session
+func (ui *chatUI) handleEvent(ev gomegle.EventData) {
+	switch ev := ev.(type) {
+	case gomegle.CountEvent:
+		ui.count = ev.N
+		return
+	case gomegle.StatusInfoEvent:
+		ui.count = ev.Status.Count
+		return
+	case gomegle.CommonLikesEvent:
+		ui.likes = ev.Topics
+	case gomegle.PartnerCollegeEvent:
+		ui.college = ev.College
+	case gomegle.MessageEvent:
+		ui.who = ""
+		ui.println("Stranger: " + ev.Text)
+		return
+	case gomegle.SpyMessageEvent:
+		ui.who = ""
+	case gomegle.SpyTypingEvent:
+		ui.who = ev.Who
+		return
+	case gomegle.SpyStoppedTypingEvent:
+		ui.who = ""
+		return
+	case gomegle.Event:
+		switch ev {
+		case gomegle.TYPING:
+			ui.who = "Stranger"
+			return
+		case gomegle.STOPPEDTYPING:
+			ui.who = ""
+			return
+		case gomegle.WAITING:
+			ui.state = "Waiting"
+			ui.likes, ui.college = nil, ""
+		case gomegle.CONNECTED:
+			ui.state = "Connected"
+			ui.failures = 0
+			ui.recorded = ui.s.Transcript()
+			ui.println(eventLine(ev))
+			if ui.asl != "" {
+				asl := ui.asl
+				ui.do(func(s *gomegle.Session) error { return s.SendMessage(asl) })
+				ui.println("You: " + asl)
+			}
+			return
+		case gomegle.DISCONNECTED, gomegle.CONNECTIONDIED:
+			ui.state = "Disconnected"
+			ui.who = ""
+		case gomegle.ANTINUDEBANNED:
+			ui.println("% You have been banned for possible bad behaviour!")
+			ui.println("% Pass -group=\"unmon\" to join unmonitored chat")
+			ui.disconnect()
+			return
+		}
+	}
+	if line := eventLine(ev); line != "" {
+		ui.println(line)
+	}
+}
+
+// Handle a key press or a terminal event
+func (ui *chatUI) handleKey(ev termbox.Event) {
+	switch ev.Type {
+	case termbox.EventError:
+		ui.println("! " + ev.Err.Error())
+		return
+	case termbox.EventKey:
+	default:
+		return
+	}
+
+	switch ev.Key {
+	case termbox.KeyCtrlC:
+		ui.quit = true
+		return
+	case termbox.KeyCtrlN, termbox.KeyEsc:
+		ui.next()
+		return
+	case termbox.KeyCtrlD:
+		if ui.s != nil {
+			ui.disconnect()
+			ui.println("- You disconnected, press Ctrl-N to find a new stranger")
+		}
+		return
+	case termbox.KeyPgup:
+		ui.scroll += 10
+		return
+	case termbox.KeyPgdn:
+		if ui.scroll -= 10; ui.scroll < 0 {
+			ui.scroll = 0
+		}
+		return
+	case termbox.KeyEnter:
+		ui.submit()
+		return
+	case termbox.KeyArrowLeft:
+		if ui.cursor > 0 {
+			ui.cursor--
+		}
+	case termbox.KeyArrowRight:
+		if ui.cursor < len(ui.input) {
+			ui.cursor++
+		}
+	case termbox.KeyHome, termbox.KeyCtrlA:
+		ui.cursor = 0
+	case termbox.KeyEnd, termbox.KeyCtrlE:
+		ui.cursor = len(ui.input)
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		if ui.cursor > 0 {
+			ui.input = append(ui.input[:ui.cursor-1], ui.input[ui.cursor:]...)
+			ui.cursor--
+		}
+	case termbox.KeyDelete:
+		if ui.cursor < len(ui.input) {
+			ui.input = append(ui.input[:ui.cursor], ui.input[ui.cursor+1:]...)
+		}
+	case termbox.KeyCtrlU:
+		ui.input, ui.cursor = nil, 0
+	default:
+		ch := ev.Ch
+		if ev.Key == termbox.KeySpace {
+			ch = ' '
+		}
+		if ch == 0 {
+			return
+		}
+		ui.input = append(ui.input, 0)
+		copy(ui.input[ui.cursor+1:], ui.input[ui.cursor:])
+		ui.input[ui.cursor] = ch
+		ui.cursor++
+	}
+	ui.updateTyping()
+}
+
+// Tell the stranger whether we are typing
+func (ui *chatUI) updateTyping() {
+	typing := len(ui.input) != 0
+	if typing == ui.typing || ui.s == nil {
+		return
+	}
+	ui.typing = typing
+	if typing {
+		ui.do((*gomegle.Session).ShowTyping)
+	} else {
+		ui.do((*gomegle.Session).StopTyping)
+	}
+}
+
+// Send what was typed to the stranger
+func (ui *chatUI) submit() {
+	line := strings.TrimSpace(string(ui.input))
+	ui.input, ui.cursor = nil, 0
+	ui.scroll = 0
+	if line == "" {
+		ui.updateTyping()
+		return
+	}
+	if strings.HasPrefix(line, "/") && !strings.HasPrefix(line, "//") {
+		ui.updateTyping()
+		go runCommand(tuiChat{ui}, ui.st, line)
+		return
+	}
+	line = strings.TrimPrefix(line, "/")
+	if ui.s == nil {
+		ui.println("! Not talking to anyone, press Ctrl-N to find a stranger")
+		return
+	}
+	ui.typing = false
+	ui.do(func(s *gomegle.Session) error { return s.SendMessage(line) })
+	ui.println("You: " + line)
+}
+
+// tuiChat lets commands, which run in their own goroutines, reach the
+// interface by running functions in the goroutine of run
+type tuiChat struct {
+	ui *chatUI
+}
+
+// Run f in the goroutine of run and report whether it was run
+func (c tuiChat) call(f func()) bool {
+	done := make(chan struct{})
+	select {
+	case c.ui.calls <- func() { f(); close(done) }:
+	case <-c.ui.stopped:
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-c.ui.stopped:
+		return false
+	}
+}
+
+func (c tuiChat) println(line string) {
+	c.call(func() { c.ui.println(line) })
+}
+
+func (c tuiChat) session() (s *gomegle.Session) {
+	c.call(func() { s = c.ui.s })
+	return s
+}
+
+func (c tuiChat) next() {
+	c.call(c.ui.next)
+}
+
+func (c tuiChat) quit() {
+	c.call(func() { c.ui.quit = true })
+}
+
+func (c tuiChat) transcript() (t *gomegle.Transcript) {
+	c.call(func() { t = c.ui.recorded })
+	return t
+}
+
+// Split line into rows of at most width cells
+func wrap(line string, width int) (rows []string) {
+	if width <= 0 {
+		return nil
+	}
+	row, w := "", 0
+	for _, r := range line {
+		rw := runewidth.RuneWidth(r)
+		if w+rw > width {
+			rows = append(rows, row)
+			row, w = "", 0
+		}
+		row += string(r)
+		w += rw
+	}
+	return append(rows, row)
+}
+
+// Colour a scrollback line by what it is
+func lineColor(line string) termbox.Attribute {
+	switch {
+	case strings.HasPrefix(line, "You: "):
+		return termbox.ColorBlue | termbox.AttrBold
+	case strings.HasPrefix(line, "Stranger"):
+		return termbox.ColorRed | termbox.AttrBold
+	case strings.HasPrefix(line, "!"), strings.HasPrefix(line, "-"):
+		return termbox.ColorYellow
+	case strings.HasPrefix(line, "%"), strings.HasPrefix(line, ">"), strings.HasPrefix(line, "+"):
+		return termbox.ColorCyan
+	}
+	return termbox.ColorDefault
+}
+
+// Print s at row y starting at column x and return the column after it
+func printAt(x, y int, s string, fg, bg termbox.Attribute) int {
+	for _, r := range s {
+		termbox.SetCell(x, y, r, fg, bg)
+		x += runewidth.RuneWidth(r)
+	}
+	return x
+}
+
+// The text of the status bar
+func (ui *chatUI) status() string {
+	parts := []string{ui.state}
+	if ui.who != "" {
+		parts = append(parts, ui.who+" is typing...")
+	}
+	if len(ui.likes) != 0 {
+		parts = append(parts, "likes: "+strings.Join(ui.likes, ", "))
+	}
+	if ui.college != "" {
+		parts = append(parts, "college: "+ui.college)
+	}
+	if ui.count != 0 {
+		parts = append(parts, fmt.Sprintf("%d online", ui.count))
+	}
+	if ui.scroll != 0 {
+		parts = append(parts, fmt.Sprintf("scrolled up %d", ui.scroll))
+	}
+	return " " + strings.Join(parts, " | ")
+}
+
+// Draw the whole screen
+func (ui *chatUI) draw() {
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	width, height := termbox.Size()
+	if height < 3 {
+		termbox.Flush()
+		return
+	}
+
+	// Scrollback, from the bottom up
+	type row struct {
+		text string
+		fg   termbox.Attribute
+	}
+	var rows []row
+	for i := len(ui.lines) - 1; i >= 0 && len(rows) < height-2+ui.scroll; i-- {
+		fg := lineColor(ui.lines[i])
+		wrapped := wrap(ui.lines[i], width)
+		for j := len(wrapped) - 1; j >= 0; j-- {
+			rows = append(rows, row{wrapped[j], fg})
+		}
+	}
+	if max := len(rows) - (height - 2); ui.scroll > max {
+		if ui.scroll = max; ui.scroll < 0 {
+			ui.scroll = 0
+		}
+	}
+	for i, y := ui.scroll, height-3; i < len(rows) && y >= 0; i, y = i+1, y-1 {
+		printAt(0, y, rows[i].text, rows[i].fg, termbox.ColorDefault)
+	}
+
+	// Status bar
+	help := "Ctrl-N next  Ctrl-D disconnect  Ctrl-C quit "
+	for x := 0; x < width; x++ {
+		termbox.SetCell(x, height-2, ' ', termbox.ColorBlack, termbox.ColorWhite)
+	}
+	printAt(0, height-2, ui.status(), termbox.ColorBlack, termbox.ColorWhite)
+	if x := width - runewidth.StringWidth(help); x > runewidth.StringWidth(ui.status())+1 {
+		printAt(x, height-2, help, termbox.ColorBlack, termbox.ColorWhite)
+	}
+
+	// Input line, scrolled horizontally so that the cursor is always visible
+	prompt := "> "
+	before := runewidth.StringWidth(string(ui.input[:ui.cursor]))
+	start := 0
+	for before-runewidth.StringWidth(string(ui.input[:start])) > width-len(prompt)-1 {
+		start++
+	}
+	x := printAt(0, height-1, prompt, termbox.ColorDefault|termbox.AttrBold, termbox.ColorDefault)
+	printAt(x, height-1, string(ui.input[start:]), termbox.ColorDefault, termbox.ColorDefault)
+	termbox.SetCursor(x+runewidth.StringWidth(string(ui.input[start:ui.cursor])), height-1)
+	termbox.Flush()
+}
@@ -0,0 +1,67 @@
+package gomegle
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Sentinel errors that can be checked for with errors.Is
+var (
+	ErrNoSession          = errors.New("no conversation has been started")
+	ErrEmptyMessage       = errors.New("message is empty")
+	ErrNoTopics           = errors.New("topic list is empty")
+	ErrEmptyIdentDigests  = errors.New("identdigests is empty")
+	ErrUnexpectedResponse = errors.New("unexpected response")
+	ErrHTTPStatus         = errors.New("unexpected HTTP status")
+	ErrRateLimited        = errors.New("rate limited by the server")
+	ErrEmptyResponse      = errors.New("empty response")
+	ErrFailed             = errors.New("server answered \"fail\"")
+	ErrRecaptchaRejected  = errors.New("recaptcha was rejected")
+	ErrServerError        = errors.New("server sent an error")
+	ErrInvalidEndpoint    = errors.New("invalid endpoint")
+	ErrNoStranger         = errors.New("no stranger connected")
+	ErrConversationEnded  = errors.New("the conversation ended")
+)
+
+// Error is returned when a method of this package fails for any other reason
+// than a network error or ctx being done. Use errors.As to get at it
+type Error struct {
+	Method     string // The method name in which the error occured
+	Err        error  // What went wrong, one of the sentinel errors above or wrapping one
+	Body       string // The response body or other offending input, if any
+	StatusCode int    // The HTTP status code, 0 if there was no response
+}
+
+// Longest part of Body that is put into the error message
+const maxErrorBody = 80
+
+// Error formats the error as "gomegle Method (Body): Err", long bodies such
+// as HTML error pages are cut short
+func (e *Error) Error() string {
+	msg := "gomegle " + e.Method
+	if e.StatusCode != 0 && (e.StatusCode < 200 || e.StatusCode > 299) {
+		msg += " [HTTP " + strconv.Itoa(e.StatusCode) + "]"
+	}
+	if body := strings.TrimSpace(e.Body); body != "" {
+		if len(body) > maxErrorBody {
+			body = body[:maxErrorBody] + "..."
+		}
+		msg += " (" + body + ")"
+	}
+	if e.Err == nil {
+		return msg + ": unknown error"
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+// Unwrap returns Err so that errors.Is works with the sentinel errors
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// unexpected returns ErrUnexpectedResponse with more details about it
+func unexpected(detail string) error {
+	return fmt.Errorf("%w: %s", ErrUnexpectedResponse, detail)
+}
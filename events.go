@@ -0,0 +1,381 @@
+package gomegle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// EventData is a single event returned by PollEvents. Events that carry no
+// data, such as WAITING or CONNECTED, are returned as plain Event values
+type EventData interface {
+	Type() Event // The event code
+}
+
+// Type returns the event itself so that Event satisfies EventData
+func (e Event) Type() Event { return e }
+
+// MessageEvent is a message from the stranger
+type MessageEvent struct {
+	Text string
+}
+
+// Type returns MESSAGE
+func (MessageEvent) Type() Event { return MESSAGE }
+
+// ErrorEvent is an error reported by the server, the session is over
+type ErrorEvent struct {
+	Text string
+}
+
+// Type returns ERROR
+func (ErrorEvent) Type() Event { return ERROR }
+
+// IdentDigestsEvent identifies the session, Digests can be passed to Generate
+type IdentDigestsEvent struct {
+	Digests string
+}
+
+// Type returns IDENTDIGESTS
+func (IdentDigestsEvent) Type() Event { return IDENTDIGESTS }
+
+// QuestionEvent is the question discussed in spy mode
+type QuestionEvent struct {
+	Text string
+}
+
+// Type returns QUESTION
+func (QuestionEvent) Type() Event { return QUESTION }
+
+// SpyTypingEvent means that one of the spyees (Who) is typing
+type SpyTypingEvent struct {
+	Who string // Such as "Stranger 1"
+}
+
+// Type returns SPYTYPING
+func (SpyTypingEvent) Type() Event { return SPYTYPING }
+
+// SpyStoppedTypingEvent means that one of the spyees (Who) stopped typing
+type SpyStoppedTypingEvent struct {
+	Who string
+}
+
+// Type returns SPYSTOPPEDTYPING
+func (SpyStoppedTypingEvent) Type() Event { return SPYSTOPPEDTYPING }
+
+// SpyDisconnectedEvent means that one of the spyees (Who) left
+type SpyDisconnectedEvent struct {
+	Who string
+}
+
+// Type returns SPYDISCONNECTED
+func (SpyDisconnectedEvent) Type() Event { return SPYDISCONNECTED }
+
+// SpyMessageEvent is a message sent by one of the spyees (Who)
+type SpyMessageEvent struct {
+	Who  string
+	Text string
+}
+
+// Type returns SPYMESSAGE
+func (SpyMessageEvent) Type() Event { return SPYMESSAGE }
+
+// ServerMessageEvent is a message from omegle itself
+type ServerMessageEvent struct {
+	Text string
+}
+
+// Type returns SERVERMESSAGE
+func (ServerMessageEvent) Type() Event { return SERVERMESSAGE }
+
+// CountEvent carries the updated number of people online
+type CountEvent struct {
+	N int
+}
+
+// Type returns COUNT
+func (CountEvent) Type() Event { return COUNT }
+
+// CommonLikesEvent lists the topics shared with the stranger
+type CommonLikesEvent struct {
+	Topics []string
+}
+
+// Type returns COMMONLIKES
+func (CommonLikesEvent) Type() Event { return COMMONLIKES }
+
+// RecaptchaRequiredEvent means that Challenge has to be answered with Recaptcha
+type RecaptchaRequiredEvent struct {
+	Challenge string
+}
+
+// Type returns RECAPTCHAREQUIRED
+func (RecaptchaRequiredEvent) Type() Event { return RECAPTCHAREQUIRED }
+
+// RecaptchaRejectedEvent means that the answer to the last challenge was
+// wrong and Challenge has to be answered instead
+type RecaptchaRejectedEvent struct {
+	Challenge string
+}
+
+// Type returns RECAPTCHAREJECTED
+func (RecaptchaRejectedEvent) Type() Event { return RECAPTCHAREJECTED }
+
+// PartnerCollegeEvent carries the college of the stranger in college mode
+type PartnerCollegeEvent struct {
+	College string
+}
+
+// Type returns PARTNERCOLLEGE
+func (PartnerCollegeEvent) Type() Event { return PARTNERCOLLEGE }
+
+// StatusInfoEvent carries an updated omegle status
+type StatusInfoEvent struct {
+	Status Status
+}
+
+// Type returns STATUSINFO
+func (StatusInfoEvent) Type() Event { return STATUSINFO }
+
+// Events without any data, by their name in the protocol
+var plainEvents = map[string]Event{
+	"waiting":              WAITING,
+	"connected":            CONNECTED,
+	"strangerDisconnected": DISCONNECTED,
+	"typing":               TYPING,
+	"stoppedTyping":        STOPPEDTYPING,
+	"connectionDied":       CONNECTIONDIED,
+	"antinudeBanned":       ANTINUDEBANNED,
+}
+
+// Get the i-th element of arr as a string or "" if it isn't one
+func argString(arr []interface{}, i int) string {
+	if i >= len(arr) {
+		return ""
+	}
+	str, _ := arr[i].(string)
+	return str
+}
+
+// parseEvent converts one element of the /events array into EventData.
+// It returns nil for events it does not know about
+func parseEvent(arr []interface{}) EventData {
+	name := argString(arr, 0)
+	if ev, ok := plainEvents[name]; ok {
+		return ev
+	}
+
+	switch name {
+	case "gotMessage":
+		return MessageEvent{argString(arr, 1)}
+	case "error":
+		return ErrorEvent{argString(arr, 1)}
+	case "identDigests":
+		return IdentDigestsEvent{argString(arr, 1)}
+	case "question":
+		return QuestionEvent{argString(arr, 1)}
+	case "spyTyping":
+		return SpyTypingEvent{argString(arr, 1)}
+	case "spyStoppedTyping":
+		return SpyStoppedTypingEvent{argString(arr, 1)}
+	case "spyDisconnected":
+		return SpyDisconnectedEvent{argString(arr, 1)}
+	case "spyMessage":
+		return SpyMessageEvent{argString(arr, 1), argString(arr, 2)}
+	case "serverMessage":
+		return ServerMessageEvent{argString(arr, 1)}
+	case "recaptchaRequired":
+		return RecaptchaRequiredEvent{argString(arr, 1)}
+	case "recaptchaRejected":
+		return RecaptchaRejectedEvent{argString(arr, 1)}
+	case "partnerCollege":
+		return PartnerCollegeEvent{argString(arr, 1)}
+	case "count":
+		if len(arr) < 2 {
+			return nil
+		}
+		num, ok := arr[1].(float64)
+		if !ok {
+			return nil
+		}
+		return CountEvent{int(num)}
+	case "commonLikes":
+		ev := CommonLikesEvent{}
+		if len(arr) < 2 {
+			return ev
+		}
+		topics, _ := arr[1].([]interface{})
+		for _, t := range topics {
+			if str, ok := t.(string); ok {
+				ev.Topics = append(ev.Topics, str)
+			}
+		}
+		return ev
+	case "statusInfo":
+		if len(arr) < 2 {
+			return nil
+		}
+		data, ok := arr[1].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		st, err := parseStatus(data)
+		if err != nil {
+			return nil
+		}
+		return StatusInfoEvent{st}
+	}
+	return nil
+}
+
+// encodeEvent converts ev back into the form parseEvent takes, an element of
+// the /events array. It returns nil for events parseEvent can't return
+func encodeEvent(ev EventData) []interface{} {
+	switch ev := ev.(type) {
+	case Event:
+		for name, e := range plainEvents {
+			if e == ev {
+				return []interface{}{name}
+			}
+		}
+	case MessageEvent:
+		return []interface{}{"gotMessage", ev.Text}
+	case ErrorEvent:
+		return []interface{}{"error", ev.Text}
+	case IdentDigestsEvent:
+		return []interface{}{"identDigests", ev.Digests}
+	case QuestionEvent:
+		return []interface{}{"question", ev.Text}
+	case SpyTypingEvent:
+		return []interface{}{"spyTyping", ev.Who}
+	case SpyStoppedTypingEvent:
+		return []interface{}{"spyStoppedTyping", ev.Who}
+	case SpyDisconnectedEvent:
+		return []interface{}{"spyDisconnected", ev.Who}
+	case SpyMessageEvent:
+		return []interface{}{"spyMessage", ev.Who, ev.Text}
+	case ServerMessageEvent:
+		return []interface{}{"serverMessage", ev.Text}
+	case RecaptchaRequiredEvent:
+		return []interface{}{"recaptchaRequired", ev.Challenge}
+	case RecaptchaRejectedEvent:
+		return []interface{}{"recaptchaRejected", ev.Challenge}
+	case PartnerCollegeEvent:
+		return []interface{}{"partnerCollege", ev.College}
+	case CountEvent:
+		return []interface{}{"count", ev.N}
+	case CommonLikesEvent:
+		return []interface{}{"commonLikes", append([]string{}, ev.Topics...)}
+	case StatusInfoEvent:
+		st := ev.Status
+		return []interface{}{"statusInfo", map[string]interface{}{
+			"count":           st.Count,
+			"force_unmon":     st.ForceUnmon,
+			"antinudeservers": st.Antinudeservers,
+			"antinudepercent": st.Antinudepercent,
+			"spyQueueTime":    st.SpyQueueTime,
+			"spyeeQueueTime":  st.SpyeeQueueTime,
+			"timestamp":       st.Timestamp,
+			"servers":         st.Servers,
+		}}
+	}
+	return nil
+}
+
+// PollEvents visits the events page and returns the new events
+func (o *Omegle) PollEvents() (events []EventData, err error) {
+	return o.PollEventsContext(context.Background())
+}
+
+// PollEventsContext is like PollEvents but aborts when ctx is done
+func (o *Omegle) PollEventsContext(ctx context.Context) (events []EventData, err error) {
+	return o.pollEvents(ctx, o.getChat())
+}
+
+// Poll the events of the conversation with the given id
+func (o *Omegle) pollEvents(ctx context.Context, c chat) (events []EventData, err error) {
+	if c.id == "" {
+		return nil, &Error{"PollEvents", ErrNoSession, "", 0}
+	}
+
+	ret, code, err := o.postRequest(ctx, "PollEvents", o.serverURL(c.server, eventCmd), map[string]string{"id": c.id})
+	if err != nil {
+		return nil, err
+	}
+	events, err = parseEvents(ret)
+	if err != nil {
+		return nil, &Error{"PollEvents", err, ret, code}
+	}
+	return events, nil
+}
+
+// parseEvents parses the body returned by /events
+func parseEvents(ret string) (events []EventData, err error) {
+	if ret == "[]" || ret == "null" {
+		return nil, nil
+	}
+
+	var otpt interface{}
+	err = json.Unmarshal([]byte(ret), &otpt)
+	if err != nil {
+		return nil, unexpected(err.Error())
+	}
+	data, ok := otpt.([]interface{})
+	if ok == false {
+		return nil, unexpected("invalid json (root element must be an array)")
+	}
+
+	for _, dv := range data {
+		arr, ok := dv.([]interface{})
+		if ok == false {
+			continue
+		}
+		if ev := parseEvent(arr); ev != nil {
+			events = append(events, ev)
+		}
+	}
+
+	if len(events) == 0 {
+		return nil, unexpected("no known events")
+	}
+	return events, nil
+}
+
+// legacyEvent converts ev into the values returned by UpdateEvents
+func legacyEvent(ev EventData) (st interface{}, msg []string) {
+	switch e := ev.(type) {
+	case Event:
+		return e, []string{}
+	case MessageEvent:
+		return MESSAGE, []string{e.Text}
+	case ErrorEvent:
+		return ERROR, []string{e.Text}
+	case IdentDigestsEvent:
+		return IDENTDIGESTS, []string{e.Digests}
+	case QuestionEvent:
+		return QUESTION, []string{e.Text}
+	case SpyTypingEvent:
+		return SPYTYPING, []string{e.Who}
+	case SpyStoppedTypingEvent:
+		return SPYSTOPPEDTYPING, []string{e.Who}
+	case SpyDisconnectedEvent:
+		return SPYDISCONNECTED, []string{e.Who}
+	case SpyMessageEvent:
+		return SPYMESSAGE, []string{e.Who, e.Text}
+	case ServerMessageEvent:
+		return SERVERMESSAGE, []string{e.Text}
+	case CountEvent:
+		return COUNT, []string{fmt.Sprintf("%f", float64(e.N))}
+	case CommonLikesEvent:
+		return COMMONLIKES, append([]string{}, e.Topics...)
+	case RecaptchaRequiredEvent:
+		return RECAPTCHAREQUIRED, []string{e.Challenge}
+	case RecaptchaRejectedEvent:
+		return RECAPTCHAREJECTED, []string{e.Challenge}
+	case PartnerCollegeEvent:
+		return PARTNERCOLLEGE, []string{e.College}
+	case StatusInfoEvent:
+		return e.Status, []string{}
+	}
+	return ev.Type(), []string{}
+}
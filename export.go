@@ -0,0 +1,182 @@
+package gomegle
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// jsonRecord is a Record as it is written by WriteJSONL
+type jsonRecord struct {
+	Time         time.Time     `json:"time"`
+	Conversation int           `json:"conversation"` // Counted from 0
+	Sent         bool          `json:"sent,omitempty"`
+	Event        []interface{} `json:"event"` // As in the /events array
+}
+
+// WriteJSONL writes the transcript as JSON Lines, one record per line. Events
+// are written in the same form omegle sends them in, such as
+// ["gotMessage","hi"]. ReadJSONL reads the transcript back
+func (t *Transcript) WriteJSONL(w io.Writer) error {
+	t.m.Lock()
+	records := append([]Record(nil), t.records...)
+	starts := append([]int(nil), t.starts...)
+	t.m.Unlock()
+
+	enc := json.NewEncoder(w)
+	conv := 0
+	for i, r := range records {
+		for conv < len(starts) && starts[conv] <= i {
+			conv++
+		}
+		ev := encodeEvent(r.Event)
+		if ev == nil {
+			continue
+		}
+		if err := enc.Encode(jsonRecord{r.Time, conv, r.Sent, ev}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadJSONL reads a transcript written by WriteJSONL
+func ReadJSONL(r io.Reader) (*Transcript, error) {
+	t := &Transcript{}
+	in := bufio.NewScanner(r)
+	in.Buffer(nil, 1024*1024)
+	conv := 0
+	for n := 1; in.Scan(); n++ {
+		line := strings.TrimSpace(in.Text())
+		if line == "" {
+			continue
+		}
+
+		var jr jsonRecord
+		if err := json.Unmarshal([]byte(line), &jr); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		ev := parseEvent(jr.Event)
+		if ev == nil {
+			return nil, fmt.Errorf("line %d: %w", n, unexpected("unknown event"))
+		}
+
+		for ; conv < jr.Conversation; conv++ {
+			t.starts = append(t.starts, len(t.records))
+		}
+		t.records = append(t.records, Record{jr.Time, ev, jr.Sent})
+	}
+	if err := in.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// WriteText writes logs as plain text, one entry per line such as
+// "Stranger: hi"
+func WriteText(w io.Writer, logs []LogEntry) error {
+	for _, e := range logs {
+		fields := e.Fields()
+		if fields == nil {
+			continue
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(fields, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Characters that have a meaning in Markdown
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`,
+	"<", "&lt;", ">", "&gt;", "#", `\#`, "|", `\|`,
+)
+
+// WriteMarkdown writes logs as Markdown, one paragraph per entry. DEF entries
+// are in italics, questions are quoted and labels are in bold
+func WriteMarkdown(w io.Writer, logs []LogEntry) error {
+	for _, e := range logs {
+		fields := e.Fields()
+		if fields == nil {
+			continue
+		}
+		for i := range fields {
+			fields[i] = markdownEscaper.Replace(fields[i])
+		}
+
+		var line string
+		switch e.Tp {
+		case DEF:
+			line = "*" + fields[0] + "*"
+		case Q:
+			line = "> **" + fields[0] + "** " + fields[1]
+		default:
+			line = "**" + fields[0] + "** " + fields[1]
+		}
+		if _, err := fmt.Fprintf(w, "%s\n\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Page written by WriteHTML, styled like the logs on logs.omegle.com
+var htmlLog = template.Must(template.New("log").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Omegle conversation log</title>
+<style>
+body { background: #f4f4f4; margin: 0; padding: 1em; font-family: Arial, Helvetica, sans-serif; }
+.log { max-width: 40em; margin: 0 auto; padding: 1em; background: #fff; border: 1px solid #ccc; border-radius: 4px; }
+.log p { margin: 0.5em 0; font-size: 1.1em; line-height: 1.3; }
+.log p.def { color: #555; font-size: 0.85em; font-weight: bold; }
+.log p.q { padding: 0.5em; background: #e8f0fd; border: 1px solid #8ab4f8; border-radius: 4px; color: #1a4fa3; }
+.log .label { font-weight: bold; }
+.log p.str .label, .log p.str1 .label { color: #f00; }
+.log p.str2 .label, .log p.you .label { color: #00f; }
+.log p.normal { font-size: 1em; }
+</style>
+</head>
+<body>
+<div class="log">
+{{range .}}<p class="{{.Class}}">{{if .Label}}<span class="label">{{.Label}}</span> {{end}}{{.Text}}</p>
+{{end}}</div>
+</body>
+</html>
+`))
+
+// Class names of the paragraphs in htmlLog by entry type
+var htmlClasses = map[Tp]string{
+	DEF:    "def",
+	Q:      "q",
+	STR:    "str",
+	STR1:   "str1",
+	STR2:   "str2",
+	YOU:    "you",
+	NORMAL: "normal",
+}
+
+// WriteHTML writes logs as a self-contained HTML page that looks like the
+// logs made by Generate
+func WriteHTML(w io.Writer, logs []LogEntry) error {
+	type paragraph struct {
+		Class, Label, Text string
+	}
+	var paragraphs []paragraph
+	for _, e := range logs {
+		fields := e.Fields()
+		switch len(fields) {
+		case 1:
+			paragraphs = append(paragraphs, paragraph{htmlClasses[e.Tp], "", fields[0]})
+		case 2:
+			paragraphs = append(paragraphs, paragraph{htmlClasses[e.Tp], fields[0], fields[1]})
+		}
+	}
+	return htmlLog.Execute(w, paragraphs)
+}
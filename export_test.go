@@ -0,0 +1,108 @@
+package gomegle
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A transcript with two conversations and every kind of event
+func exampleTranscript() *Transcript {
+	at := time.Date(2014, 6, 1, 12, 0, 0, 0, time.UTC)
+	tr := &Transcript{}
+	events := []EventData{
+		WAITING, CONNECTED, CommonLikesEvent{[]string{"cats", "dogs"}},
+		IdentDigestsEvent{"a,b,c,d"}, TYPING, MessageEvent{"hi *there*"},
+		CountEvent{42}, PartnerCollegeEvent{"ktu.edu"},
+		StatusInfoEvent{Status{Count: 5, Antinudeservers: []string{"waw1"}, Servers: []string{"front1"}}},
+	}
+	for i, ev := range events {
+		tr.records = append(tr.records, Record{at.Add(time.Duration(i) * time.Second), ev, false})
+	}
+	tr.records = append(tr.records, Record{at.Add(time.Minute), MessageEvent{"<b>hello</b>"}, true})
+	tr.records = append(tr.records, Record{at.Add(time.Minute), DISCONNECTED, true})
+	tr.starts = []int{len(tr.records)}
+	tr.records = append(tr.records, Record{at.Add(time.Hour), QuestionEvent{"Why?"}, false})
+	tr.records = append(tr.records, Record{at.Add(time.Hour), SpyMessageEvent{"Stranger 2", "because"}, false})
+	return tr
+}
+
+func TestJSONLRoundTrip(t *testing.T) {
+	tr := exampleTranscript()
+	var buf bytes.Buffer
+	if err := tr.WriteJSONL(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != len(tr.records) {
+		t.Errorf("got %d lines, want %d", n, len(tr.records))
+	}
+
+	back, err := ReadJSONL(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back.Records(), tr.Records()) {
+		t.Errorf("got %v, want %v", back.Records(), tr.Records())
+	}
+	if len(back.Conversations()) != 2 {
+		t.Error("conversations were lost")
+	}
+
+	if _, err := ReadJSONL(strings.NewReader(`{"event":["bogus"]}`)); err == nil {
+		t.Error("expected an error for an unknown event")
+	}
+}
+
+func TestJSONLStatusWithoutServers(t *testing.T) {
+	tr := &Transcript{}
+	st := Status{Count: 5, Antinudeservers: []string{"waw1"}, Timestamp: 1403617468}
+	tr.records = []Record{{time.Date(2014, 6, 1, 12, 0, 0, 0, time.UTC), StatusInfoEvent{st}, false}}
+	var buf bytes.Buffer
+	if err := tr.WriteJSONL(&buf); err != nil {
+		t.Fatal(err)
+	}
+	back, err := ReadJSONL(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back.Records(), tr.Records()) {
+		t.Errorf("got %v, want %v", back.Records(), tr.Records())
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	logs := exampleTranscript().LogEntries()
+
+	var buf bytes.Buffer
+	if err := WriteText(&buf, logs); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"You both like cats, dogs.\n", "Stranger: hi *there*\n", "You: <b>hello</b>\n", "Stranger 2: because\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("text is missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := WriteMarkdown(&buf, logs); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`**Stranger:** hi \*there\*`, "**You:** &lt;b&gt;hello&lt;/b&gt;", "> **Question to discuss:** Why?", "*You have disconnected.*"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("markdown is missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := WriteHTML(&buf, logs); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<p class="str"><span class="label">Stranger:</span> hi *there*</p>`,
+		`<p class="you"><span class="label">You:</span> &lt;b&gt;hello&lt;/b&gt;</p>`, `<p class="q">`, `<p class="def">`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("HTML is missing %q:\n%s", want, buf.String())
+		}
+	}
+}
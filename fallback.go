@@ -0,0 +1,51 @@
+package gomegle
+
+import (
+	"sync"
+	"time"
+)
+
+// TopicFallback tells a Session what to do when no stranger sharing its
+// topics connects in time. Set Omegle.TopicFallback to use it. The same
+// fallback can be shared by many sessions, it learns how popular the topics
+// are from the common likes they find
+type TopicFallback struct {
+	Timeout time.Duration // How long to wait for a stranger before each step, the fallback is off if 0
+	// Optional, if true then the search is started again without the least
+	// popular topic after every Timeout until one topic is left, before
+	// looking for any stranger. Topics that are equally popular are dropped
+	// from the end of the list
+	Broaden bool
+	// Optional, called with the topics searched for after every step, none
+	// after falling back to any stranger. Sessions call it from their own
+	// goroutines so it has to be safe for concurrent use
+	OnFallback func(topics []string)
+
+	m     sync.Mutex
+	likes map[string]int // How many times every topic was a common like
+}
+
+// Remember the common likes of a conversation
+func (f *TopicFallback) liked(topics []string) {
+	defer f.m.Unlock()
+	f.m.Lock()
+	if f.likes == nil {
+		f.likes = make(map[string]int)
+	}
+	for _, t := range topics {
+		f.likes[t]++
+	}
+}
+
+// Get topics without the least popular one
+func (f *TopicFallback) drop(topics []string) []string {
+	defer f.m.Unlock()
+	f.m.Lock()
+	least := len(topics) - 1
+	for i := least - 1; i >= 0; i-- {
+		if f.likes[topics[i]] < f.likes[topics[least]] {
+			least = i
+		}
+	}
+	return append(append([]string(nil), topics[:least]...), topics[least+1:]...)
+}
@@ -0,0 +1,106 @@
+package gomegle
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GiedriusS/gomegle/gomegletest"
+)
+
+// Start a session on a fake server on which strangers never connect by
+// themselves
+func startManual(t *testing.T, o *Omegle) (*gomegletest.Server, *Session) {
+	manual := gomegletest.NewUnstartedServer()
+	manual.Manual = true
+	manual.Start()
+	t.Cleanup(manual.Close)
+
+	o.Client = &http.Client{Transport: manual.Transport()}
+	s, err := o.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return manual, s
+}
+
+// Wait for a CONNECTED event
+func waitConnected(t *testing.T, s *Session) {
+	for ev := range s.Events() {
+		if ev == CONNECTED {
+			return
+		}
+	}
+	t.Fatal("the session ended before a stranger connected")
+}
+
+func TestTopicFallback(t *testing.T) {
+	steps := make(chan []string, 10)
+	manual, s := startManual(t, &Omegle{
+		Topics:        []string{"cats", "dogs"},
+		TopicFallback: &TopicFallback{Timeout: 50 * time.Millisecond, OnFallback: func(t []string) { steps <- t }},
+	})
+
+	waitConnected(t, s)
+	if !manual.WaitChat(0, time.Second).StoppedLooking() {
+		t.Error("did not stop looking for common likes")
+	}
+	if len(manual.Chats()) != 1 {
+		t.Error("started a new conversation without Broaden")
+	}
+	if len(steps) != 1 || <-steps != nil {
+		t.Error("expected a single step to no topics")
+	}
+}
+
+func TestTopicFallbackBroaden(t *testing.T) {
+	fallback := &TopicFallback{Timeout: 50 * time.Millisecond, Broaden: true}
+	fallback.liked([]string{"dogs"})
+	var steps [][]string
+	fallback.OnFallback = func(t []string) { steps = append(steps, t) }
+	manual, s := startManual(t, &Omegle{Topics: []string{"cats", "dogs", "birds"}, TopicFallback: fallback, Record: true})
+
+	waitConnected(t, s)
+	chats := manual.Chats()
+	var topics [][]string
+	for _, c := range chats {
+		topics = append(topics, c.Topics)
+	}
+	if want := [][]string{{"cats", "dogs", "birds"}, {"cats", "dogs"}, {"dogs"}}; !reflect.DeepEqual(topics, want) {
+		t.Errorf("started conversations with topics %v, want %v", topics, want)
+	}
+	for _, c := range chats[:len(chats)-1] {
+		if !c.Ended() {
+			t.Error("a broadened conversation was not left")
+		}
+	}
+	if !chats[len(chats)-1].StoppedLooking() {
+		t.Error("did not stop looking for common likes with one topic left")
+	}
+	if want := [][]string{{"cats", "dogs"}, {"dogs"}, nil}; !reflect.DeepEqual(steps, want) {
+		t.Errorf("got steps %v, want %v", steps, want)
+	}
+	if s.ID() != chats[len(chats)-1].ID {
+		t.Error("the session is not in the last conversation")
+	}
+	if n := len(s.Transcript().Conversations()); n != 3 {
+		t.Errorf("got %d conversations in the transcript, want 3", n)
+	}
+}
+
+func TestTopicFallbackConnected(t *testing.T) {
+	manual, s := startManual(t, &Omegle{
+		Topics:        []string{"cats"},
+		TopicFallback: &TopicFallback{Timeout: 200 * time.Millisecond, Broaden: true},
+	})
+	chat := manual.WaitChat(0, time.Second)
+	chat.Connect()
+	waitConnected(t, s)
+	time.Sleep(300 * time.Millisecond)
+	if chat.StoppedLooking() || len(manual.Chats()) != 1 {
+		t.Error("fell back after a stranger connected")
+	}
+}
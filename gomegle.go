@@ -2,6 +2,7 @@
 package gomegle
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,12 +10,18 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
-var random *rand.Rand // private RNG
+var random *rand.Rand  // private RNG
+var randomM sync.Mutex // synchronises access to random
+
+// defaultClient is shared by all Omegle values without a Client so that
+// connections are kept alive between requests
+var defaultClient = &http.Client{}
 
 // Various commands sent to the omegle servers
 const (
@@ -57,33 +64,23 @@ const (
 	RECAPTCHAREJECTED
 	// Only in college mode, the stranger's college
 	PARTNERCOLLEGE
+	STATUSINFO // Updated omegle status
 )
 
 // Event is a type used for storing the above event codes
 type Event int
 
-// A private struct for storing errors
-type omegleErr struct {
-	method string // The method name in which the error occured
-	err    string // Actual error message
-	buf    string // Buffer that could be used to store the returned result
-}
-
-// Mandatory function to satisfy the interface
-func (e *omegleErr) Error() string {
-	if e.buf == "" {
-		return "gomegle " + e.method + ": " + e.err
-	}
-	return "gomegle " + e.method + " (" + e.buf + "): " + e.err
-}
-
-// Omegle stores information about the connection to omegle.com
+// Omegle stores information about the connection to omegle.com. Use Start
+// to begin any number of independent conversations with this configuration.
+// The methods of Omegle itself (GetID, SendMessage, ...) drive a single
+// conversation stored inside of it
 type Omegle struct {
 	id              string       // Private member used for identifying ourselves to omegle
+	chatServer      string       // Private member, the server id was handed out by
 	Lang            string       // Optional, two character language code
 	Group           string       // Optional, "unmon" to join unmonitored chat
 	Server          string       // Optional, can specify a certain server to use
-	idM             sync.RWMutex // Private member used for synchronising access to id
+	idM             sync.RWMutex // Private member used for synchronising access to id and randid
 	Question        string       // Optional, if not empty used as the question in "spyer" mode
 	Cansavequestion bool         // Optional, if question is not "" then permit omegle to save the question
 	Wantsspy        bool         // Optional, if true then "spyee" mode is started
@@ -92,6 +89,91 @@ type Omegle struct {
 	College         string       // Optional, if not empty must exactly match the college identifier as on omegle.com (such as "ktu.edu")
 	CollegeAuth     string       // Optional, if not empty then used as identifier of your college. You need to get this from omegle.com
 	AnyCollege      bool         // Optional, if in college mode then it will connect you to any college
+	Endpoint        Endpoint     // Optional, where the chat servers are, DefaultEndpoint if Host is empty
+	LogEndpoint     Endpoint     // Optional, where the log server is, DefaultLogEndpoint if Host is empty
+	Client          *http.Client // Optional, used for all requests instead of a shared default client
+	// Optional, if not nil then sessions start a new conversation when the
+	// current one ends as allowed by the policy
+	Reconnect *ReconnectPolicy
+	// Optional, if not nil then the front server is picked from the pool
+	// instead of using Server and others are tried when it fails
+	Pool *ServerPool
+	// Optional, if true then sessions record everything that happens into a
+	// Transcript
+	Record bool
+	// Optional, if not nil then sessions with Topics stop insisting on them
+	// when no stranger connects in time
+	TopicFallback *TopicFallback
+}
+
+// Endpoint describes where a group of omegle servers can be reached
+type Endpoint struct {
+	Scheme string // Optional, "http" if empty
+	Host   string // Host name without the port
+	Port   int    // Optional, if 0 then the default port of Scheme is used
+	Path   string // Optional, path prefix that is put before every command
+	// Optional, if true then the server is put into the path
+	// (host/path/server/cmd) instead of the host name (server.host/path/cmd)
+	ServerInPath bool
+}
+
+// Default endpoints used when Omegle.Endpoint or Omegle.LogEndpoint are empty
+var (
+	DefaultEndpoint    = Endpoint{Scheme: "http", Host: "omegle.com"}
+	DefaultLogEndpoint = Endpoint{Scheme: "http", Host: "logs.omegle.com"}
+)
+
+// ParseEndpoint parses an URL such as "https://example.com:8080/omegle" into
+// an Endpoint. A path ending in "/{server}", such as
+// "http://127.0.0.1:8080/omegle/{server}", puts the server into the path
+func ParseEndpoint(rawurl string) (e Endpoint, err error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	if u.Host == "" {
+		return Endpoint{}, &Error{"ParseEndpoint", fmt.Errorf("%w: no host in URL", ErrInvalidEndpoint), rawurl, 0}
+	}
+
+	e.Scheme = u.Scheme
+	e.Host = u.Hostname()
+	if port := u.Port(); port != "" {
+		e.Port, err = strconv.Atoi(port)
+		if err != nil {
+			return Endpoint{}, err
+		}
+	}
+	e.Path = u.Path
+	if p := strings.TrimSuffix(e.Path, "/{server}"); p != e.Path {
+		e.Path = p
+		e.ServerInPath = true
+	}
+	return e, nil
+}
+
+// URL builds the address of cmd on the given server, or on the main server if
+// server is empty
+func (e Endpoint) URL(server, cmd string) string {
+	scheme := e.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	host := e.Host
+	if server != "" && !e.ServerInPath {
+		host = server + "." + host
+	}
+	if e.Port != 0 {
+		host += ":" + strconv.Itoa(e.Port)
+	}
+
+	path := strings.Trim(e.Path, "/")
+	if server != "" && e.ServerInPath {
+		path += "/" + server
+	}
+	path = strings.TrimPrefix(path+"/"+cmd, "/")
+
+	return scheme + "://" + host + "/" + path
 }
 
 // Status stores information about omegle status
@@ -108,57 +190,143 @@ type Status struct {
 	Servers        []string
 }
 
-// Build a URL from o.Server and cmd that will be used for communication
+// Build a URL from o.Endpoint, o.Server and cmd that will be used for communication
 func (o *Omegle) buildURL(cmd string) string {
-	if o.Server == "" {
-		return "http://omegle.com/" + cmd
+	return o.serverURL(o.Server, cmd)
+}
+
+// Build a URL from o.Endpoint, server and cmd
+func (o *Omegle) serverURL(server, cmd string) string {
+	e := o.Endpoint
+	if e.Host == "" {
+		e = DefaultEndpoint
 	}
-	return "http://" + o.Server + ".omegle.com/" + cmd
+	return e.URL(server, cmd)
 }
 
-// Change the id
-func (o *Omegle) setID(id string) {
+// Build a URL from o.LogEndpoint and cmd for talking to the log server
+func (o *Omegle) buildLogURL(cmd string) string {
+	e := o.LogEndpoint
+	if e.Host == "" {
+		e = DefaultLogEndpoint
+	}
+	return e.URL("", cmd)
+}
+
+// Config returns a copy of the configuration in o without any conversation
+// state, Topics is copied too so that the copy can be used on its own
+func (o *Omegle) Config() *Omegle {
+	return &Omegle{
+		Lang:            o.Lang,
+		Group:           o.Group,
+		Server:          o.Server,
+		Question:        o.Question,
+		Cansavequestion: o.Cansavequestion,
+		Wantsspy:        o.Wantsspy,
+		Topics:          append([]string(nil), o.Topics...),
+		College:         o.College,
+		CollegeAuth:     o.CollegeAuth,
+		AnyCollege:      o.AnyCollege,
+		Endpoint:        o.Endpoint,
+		LogEndpoint:     o.LogEndpoint,
+		Client:          o.Client,
+		Reconnect:       o.Reconnect,
+		Pool:            o.Pool,
+		Record:          o.Record,
+		TopicFallback:   o.TopicFallback,
+	}
+}
+
+// chat identifies a conversation and the front server it was started on
+type chat struct {
+	server string
+	id     string
+}
+
+// Change the conversation
+func (o *Omegle) setChat(c chat) {
 	defer o.idM.Unlock()
 	o.idM.Lock()
-	o.id = id
+	o.id = c.id
+	o.chatServer = c.server
 }
 
-// Get the id
-func (o *Omegle) getID() (id string) {
+// Get the conversation
+func (o *Omegle) getChat() chat {
 	defer o.idM.RUnlock()
 	o.idM.RLock()
-	id = o.id
-	return
+	return chat{o.chatServer, o.id}
 }
 
-// Send a POST request with specified parameters and values
-func postRequest(link string, parameters map[string]string) (body string, err error) {
-	data := url.Values{}
-	for k, v := range parameters {
-		data.Set(k, v)
+// Get the id
+func (o *Omegle) getID() (id string) {
+	return o.getChat().id
+}
+
+// Get the HTTP client used for all requests
+func (o *Omegle) client() *http.Client {
+	if o.Client != nil {
+		return o.Client
 	}
+	return defaultClient
+}
 
-	resp, err := http.PostForm(link, data)
+// Send a request and read the whole body so that the connection can be reused.
+// If ctx is done then its error is returned instead of the one from net/http.
+// Responses that can't be a reply to any command are turned into an *Error
+// for method: HTTP errors, rate limiting and empty bodies
+func (o *Omegle) do(ctx context.Context, method string, req *http.Request) (body string, code int, err error) {
+	resp, err := o.client().Do(req)
 	if err != nil {
-		return "", err
+		if ctx.Err() != nil {
+			return "", 0, ctx.Err()
+		}
+		return "", 0, err
 	}
 	defer resp.Body.Close()
 
 	ret, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		if ctx.Err() != nil {
+			return "", resp.StatusCode, ctx.Err()
+		}
+		return "", resp.StatusCode, err
 	}
 
-	return string(ret), nil
+	body, code = string(ret), resp.StatusCode
+	switch {
+	case code == http.StatusTooManyRequests ||
+		(code == http.StatusServiceUnavailable && resp.Header.Get("Retry-After") != ""):
+		return body, code, &Error{method, ErrRateLimited, body, code}
+	case code < 200 || code > 299:
+		return body, code, &Error{method, ErrHTTPStatus, body, code}
+	case strings.TrimSpace(body) == "":
+		return body, code, &Error{method, ErrEmptyResponse, body, code}
+	}
+	return body, code, nil
 }
 
-// Send a GET request with specified parameters and values
-func getRequest(link string, parameters map[string]string) (body string, err error) {
-	client := &http.Client{}
+// Send a POST request with specified parameters and values
+func (o *Omegle) postRequest(ctx context.Context, method, link string, parameters map[string]string) (body string, code int, err error) {
+	data := url.Values{}
+	for k, v := range parameters {
+		data.Set(k, v)
+	}
 
+	req, err := http.NewRequestWithContext(ctx, "POST", link, strings.NewReader(data.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return o.do(ctx, method, req)
+}
+
+// Send a GET request with specified parameters and values
+func (o *Omegle) getRequest(ctx context.Context, method, link string, parameters map[string]string) (body string, code int, err error) {
 	u, err := url.Parse(link)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
 	query := u.Query()
@@ -167,46 +335,59 @@ func getRequest(link string, parameters map[string]string) (body string, err err
 	}
 	u.RawQuery = query.Encode()
 
-	req, err := http.NewRequest("GET", u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
+	return o.do(ctx, method, req)
+}
 
-	ret, err := ioutil.ReadAll(resp.Body)
+// Send a command about the conversation c which is answered with "win" on success
+func (o *Omegle) command(ctx context.Context, method string, c chat, cmd string, parameters map[string]string) error {
+	parameters["id"] = c.id
+	ret, code, err := o.postRequest(ctx, method, o.serverURL(c.server, cmd), parameters)
 	if err != nil {
-		return "", err
+		return err
 	}
-
-	return string(ret), nil
+	if ret == "fail" {
+		return &Error{method, ErrFailed, ret, code}
+	}
+	if ret != "win" {
+		return &Error{method, unexpected("expected \"win\""), ret, code}
+	}
+	return nil
 }
 
-// generateRandID generates a random id and stores it if o.randid is not empty
-func (o *Omegle) generateRandID() {
-	if len(o.randid) != 0 {
-		return
-	}
+// newRandID generates a random string of 8 chars length with 2-9 and A-Z
+func newRandID() (randid string) {
+	defer randomM.Unlock()
+	randomM.Lock()
 
 	// Extracted from omegle source code
 	const chars = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
 	for i := 0; i < 8; i++ {
-		o.randid += string(chars[random.Intn(len(chars))])
+		randid += string(chars[random.Intn(len(chars))])
 	}
+	return
 }
 
-// Get a new ID but without any locking
-func (o *Omegle) getidUnlocked() (id string, err error) {
-	o.generateRandID()
+// generateRandID generates a random id and stores it if o.randid is empty
+func (o *Omegle) generateRandID() (randid string) {
+	defer o.idM.Unlock()
+	o.idM.Lock()
+	if len(o.randid) == 0 {
+		o.randid = newRandID()
+	}
+	return o.randid
+}
 
+// Start a new conversation and return its id
+func (o *Omegle) start(ctx context.Context, randid string) (c chat, err error) {
 	params := map[string]string{}
 	params["lang"] = o.Lang
 	params["group"] = o.Group
-	params["randid"] = o.randid
+	params["randid"] = randid
 
 	if o.Wantsspy == true {
 		params["wantsspy"] = "1"
@@ -224,7 +405,7 @@ func (o *Omegle) getidUnlocked() (id string, err error) {
 		}
 		b, err := json.Marshal(o.Topics)
 		if err != nil {
-			return "", err
+			return chat{}, err
 		}
 		if len(o.Topics) != 0 {
 			params["topics"] = string(b)
@@ -232,215 +413,164 @@ func (o *Omegle) getidUnlocked() (id string, err error) {
 	} else {
 		b, err := json.Marshal(o.Topics)
 		if err != nil {
-			return "", err
+			return chat{}, err
 		}
 		if len(o.Topics) != 0 {
 			params["topics"] = string(b)
 		}
 	}
 
-	resp, err := getRequest(o.buildURL(startCmd), params)
+	if o.Pool == nil {
+		return o.startOn(ctx, o.Server, params)
+	}
+
+	servers, err := o.Pool.candidates(ctx, o)
 	if err != nil {
-		return "", err
+		return chat{}, err
 	}
-	return strings.Trim(string(resp), "\""), nil
+	for _, server := range servers {
+		c, err = o.startOn(ctx, server, params)
+		if err == nil {
+			o.Pool.succeeded(server)
+			return c, nil
+		}
+		if ctx.Err() != nil {
+			return chat{}, err
+		}
+		o.Pool.failed(server)
+	}
+	return chat{}, err
+}
+
+// Start a new conversation on the given front server
+func (o *Omegle) startOn(ctx context.Context, server string, params map[string]string) (c chat, err error) {
+	resp, code, err := o.getRequest(ctx, "Start", o.serverURL(server, startCmd), params)
+	if err != nil {
+		return chat{}, err
+	}
+	id := strings.Trim(resp, "\"")
+	if id == "" || strings.ContainsAny(id, "\"<> \t\r\n") {
+		return chat{}, &Error{"Start", unexpected("expected a conversation id"), resp, code}
+	}
+	return chat{server, id}, nil
 }
 
 // GetID gets and sets a new id
 func (o *Omegle) GetID() (err error) {
-	id, err := o.getidUnlocked()
+	return o.GetIDContext(context.Background())
+}
+
+// GetIDContext is like GetID but aborts when ctx is done
+func (o *Omegle) GetIDContext(ctx context.Context) (err error) {
+	c, err := o.start(ctx, o.generateRandID())
 	if err != nil {
 		return err
 	}
-	o.setID(id)
+	o.setChat(c)
 	return nil
 }
 
 // ShowTyping shows to the stranger that we are typing
 func (o *Omegle) ShowTyping() (err error) {
-	if o.getID() == "" {
-		return &omegleErr{"ShowTyping", "id is empty", ""}
-	}
+	return o.ShowTypingContext(context.Background())
+}
 
-	ret, err := postRequest(o.buildURL(typingCmd), map[string]string{"id": o.getID()})
-	if ret != "win" {
-		return &omegleErr{"ShowTyping", "returned something other than win", ret}
+// ShowTypingContext is like ShowTyping but aborts when ctx is done
+func (o *Omegle) ShowTypingContext(ctx context.Context) (err error) {
+	return o.showTyping(ctx, o.getChat())
+}
+
+// Show or hide typing in the conversation with the given id
+func (o *Omegle) showTyping(ctx context.Context, c chat) (err error) {
+	if c.id == "" {
+		return &Error{"ShowTyping", ErrNoSession, "", 0}
 	}
-	return
+
+	return o.command(ctx, "ShowTyping", c, typingCmd, map[string]string{})
 }
 
 // StopTyping shows to the stranger that we stopped typing
 func (o *Omegle) StopTyping() (err error) {
-	if o.getID() == "" {
-		return &omegleErr{"StopTyping", "id is empty", ""}
-	}
+	return o.StopTypingContext(context.Background())
+}
 
-	ret, err := postRequest(o.buildURL(stoptypingCmd), map[string]string{"id": o.getID()})
-	if ret != "win" {
-		return &omegleErr{"StopTyping", "returned something other than win", ret}
+// StopTypingContext is like StopTyping but aborts when ctx is done
+func (o *Omegle) StopTypingContext(ctx context.Context) (err error) {
+	return o.stopTyping(ctx, o.getChat())
+}
+
+// Show or hide typing in the conversation with the given id
+func (o *Omegle) stopTyping(ctx context.Context, c chat) (err error) {
+	if c.id == "" {
+		return &Error{"StopTyping", ErrNoSession, "", 0}
 	}
-	return
+
+	return o.command(ctx, "StopTyping", c, stoptypingCmd, map[string]string{})
 }
 
 // Disconnect from the Omegle server
 func (o *Omegle) Disconnect() (err error) {
-	if o.getID() == "" {
-		return &omegleErr{"Disconnect", "id is empty", ""}
-	}
-	ret, err := postRequest(o.buildURL(disconnectCmd), map[string]string{"id": o.id})
+	return o.DisconnectContext(context.Background())
+}
 
-	if err != nil {
-		return
-	}
-	if ret != "win" {
-		return &omegleErr{"Disconnect", "returned something other than win", ret}
-	}
+// DisconnectContext is like Disconnect but aborts when ctx is done
+func (o *Omegle) DisconnectContext(ctx context.Context) (err error) {
+	return o.disconnect(ctx, o.getChat())
+}
 
-	return nil
+// Leave the conversation with the given id
+func (o *Omegle) disconnect(ctx context.Context, c chat) (err error) {
+	if c.id == "" {
+		return &Error{"Disconnect", ErrNoSession, "", 0}
+	}
+	return o.command(ctx, "Disconnect", c, disconnectCmd, map[string]string{})
 }
 
 // SendMessage sends a message to the stranger
 func (o *Omegle) SendMessage(msg string) (err error) {
-	if o.getID() == "" {
-		return &omegleErr{"SendMessage", "id is empty", ""}
-	}
-	if msg == "" {
-		return &omegleErr{"SendMessage", "msg is empty", ""}
-	}
+	return o.SendMessageContext(context.Background(), msg)
+}
 
-	ret, err := postRequest(o.buildURL(sendCmd), map[string]string{"id": o.getID(), "msg": msg})
-	if err != nil {
-		return
+// SendMessageContext is like SendMessage but aborts when ctx is done
+func (o *Omegle) SendMessageContext(ctx context.Context, msg string) (err error) {
+	return o.sendMessage(ctx, o.getChat(), msg)
+}
+
+// Send a message in the conversation with the given id
+func (o *Omegle) sendMessage(ctx context.Context, c chat, msg string) (err error) {
+	if c.id == "" {
+		return &Error{"SendMessage", ErrNoSession, "", 0}
 	}
-	if ret != "win" {
-		return &omegleErr{"SendMessage", "returned something else than win", ret}
+	if msg == "" {
+		return &Error{"SendMessage", ErrEmptyMessage, "", 0}
 	}
 
-	return nil
+	return o.command(ctx, "SendMessage", c, sendCmd, map[string]string{"msg": msg})
 }
 
-// UpdateEvents visits the events page and gathers new events
+// UpdateEvents visits the events page and gathers new events. Every element
+// of st is either an Event or a Status and msg holds the strings that came
+// with it. New code should use PollEvents instead
 func (o *Omegle) UpdateEvents() (st []interface{}, msg [][]string, err error) {
-	if o.getID() == "" {
-		return st, [][]string{}, &omegleErr{"UpdateEvents", "id is empty", ""}
-	}
+	return o.UpdateEventsContext(context.Background())
+}
 
-	ret, err := postRequest(o.buildURL(eventCmd), map[string]string{"id": o.getID()})
+// UpdateEventsContext is like UpdateEvents but aborts when ctx is done
+func (o *Omegle) UpdateEventsContext(ctx context.Context) (st []interface{}, msg [][]string, err error) {
+	events, err := o.PollEventsContext(ctx)
 	if err != nil {
 		return st, [][]string{}, err
 	}
-	if ret == "[]" || ret == "null" {
-		return st, [][]string{}, nil
-	}
 
-	var otpt interface{}
-	err = json.Unmarshal([]byte(ret), &otpt)
-	if err != nil {
-		return st, [][]string{}, err
+	for _, ev := range events {
+		s, m := legacyEvent(ev)
+		st = append(st, s)
+		msg = append(msg, m)
 	}
-	data, ok := otpt.([]interface{})
-	if ok == false {
-		return st, [][]string{}, &omegleErr{"UpdateEvents", "invalid json (root element must be an array)", ret}
-	}
-
-	for _, dv := range data {
-		arr, ok := dv.([]interface{})
-		if ok == false {
-			continue
-		}
-
-		status := ""
-		if str, ok := arr[0].(string); ok {
-			status = str
-		}
-		if status == "" {
-			continue
-		}
-
-		switch status {
-		case "count":
-			st = append(st, COUNT)
-		case "antinudeBanned":
-			st = append(st, ANTINUDEBANNED)
-		case "connectionDied":
-			st = append(st, CONNECTIONDIED)
-		case "error":
-			st = append(st, ERROR)
-		case "waiting":
-			st = append(st, WAITING)
-		case "spyDisconnected":
-			st = append(st, SPYDISCONNECTED)
-		case "strangerDisconnected":
-			st = append(st, DISCONNECTED)
-		case "connected":
-			st = append(st, CONNECTED)
-		case "stoppedTyping":
-			st = append(st, STOPPEDTYPING)
-		case "typing":
-			st = append(st, TYPING)
-		case "gotMessage":
-			st = append(st, MESSAGE)
-		case "identDigests":
-			st = append(st, IDENTDIGESTS)
-		case "spyTyping":
-			st = append(st, SPYTYPING)
-		case "spyStoppedTyping":
-			st = append(st, SPYSTOPPEDTYPING)
-		case "spyMessage":
-			st = append(st, SPYMESSAGE)
-		case "serverMessage":
-			st = append(st, SERVERMESSAGE)
-		case "question":
-			st = append(st, QUESTION)
-		case "recaptchaRequired":
-			st = append(st, RECAPTCHAREQUIRED)
-		case "recaptchaRejected":
-			st = append(st, RECAPTCHAREJECTED)
-		case "commonLikes":
-			st = append(st, COMMONLIKES)
-		case "partnerCollege":
-			st = append(st, PARTNERCOLLEGE)
-		case "statusInfo":
-			if len(arr) < 2 {
-				continue
-			}
-			data, ok := arr[1].(map[string]interface{})
-			if !ok {
-				continue
-			}
-			parsed, err := parseStatus(data)
-			if err != nil {
-				continue
-			}
-			st = append(st, parsed)
-			msg = append(msg, []string{})
-			continue
-		default:
-			continue
-		}
-
-		messages := []string{}
-		for i := 1; i < len(arr); i++ {
-			if str, ok := arr[i].(string); ok {
-				messages = append(messages, str)
-			} else if fl, ok := arr[i].(float64); ok {
-				messages = append(messages, fmt.Sprintf("%f", fl))
-			} else if strm, ok := arr[i].([]interface{}); ok {
-				for j := 0; j < len(strm); j++ {
-					if str, ok := strm[j].(string); ok {
-						messages = append(messages, str)
-					}
-				}
-			}
-		}
-		msg = append(msg, messages)
-	}
-
-	if len(st) != 0 {
-		return st, msg, nil
+	if len(st) == 0 {
+		return st, [][]string{}, nil
 	}
-
-	return st, [][]string{}, &omegleErr{"UpdateEvents", "unknown error", ret}
+	return st, msg, nil
 }
 
 // convertAndParse parses status from a string
@@ -448,14 +578,18 @@ func convertAndParse(resp string) (st Status, err error) {
 	var otpt interface{}
 	err = json.Unmarshal([]byte(resp), &otpt)
 	if err != nil {
-		return Status{}, err
+		return Status{}, unexpected(err.Error())
 	}
 
 	data, ok := otpt.(map[string]interface{})
 	if ok == false {
-		return Status{}, &omegleErr{"convertAndParse", "failed to find an JSON object", resp}
+		return Status{}, unexpected("failed to find an JSON object")
+	}
+	st, err = parseStatus(data)
+	if err == nil && len(st.Servers) == 0 {
+		return st, unexpected("failed to parse servers")
 	}
-	return parseStatus(data)
+	return st, err
 }
 
 // parseStatus parses status from a map[string]interface{}
@@ -463,7 +597,7 @@ func parseStatus(data map[string]interface{}) (st Status, err error) {
 	if num, ok := data["count"].(float64); ok {
 		st.Count = int(num)
 	} else {
-		return st, &omegleErr{"parseStatus", "failed to parse count", ""}
+		return st, unexpected("failed to parse count")
 	}
 
 	if d, ok := data["force_unmon"].(bool); ok {
@@ -479,33 +613,34 @@ func parseStatus(data map[string]interface{}) (st Status, err error) {
 	}
 
 	if len(st.Antinudeservers) == 0 {
-		return st, &omegleErr{"parseStatus", "failed to parse antinudeservers", ""}
+		return st, unexpected("failed to parse antinudeservers")
 	}
 
 	if num, ok := data["antinudepercent"].(float64); ok {
 		st.Antinudepercent = num
 	} else {
-		return st, &omegleErr{"parseStatus", "failed to parse antinudepercent", ""}
+		return st, unexpected("failed to parse antinudepercent")
 	}
 
 	if num, ok := data["spyeeQueueTime"].(float64); ok {
 		st.SpyeeQueueTime = num
 	} else {
-		return st, &omegleErr{"parseStatus", "failed to parse spyeeQueueTime", ""}
+		return st, unexpected("failed to parse spyeeQueueTime")
 	}
 
 	if num, ok := data["spyQueueTime"].(float64); ok {
 		st.SpyQueueTime = num
 	} else {
-		return st, &omegleErr{"parseStatus", "failed to parse spyQueueTime", ""}
+		return st, unexpected("failed to parse spyQueueTime")
 	}
 
 	if num, ok := data["timestamp"].(float64); ok {
 		st.Timestamp = num
 	} else {
-		return st, &omegleErr{"parseStatus", "failed to parse timestamp", ""}
+		return st, unexpected("failed to parse timestamp")
 	}
 
+	// /status always lists the servers but statusInfo events may not
 	if d, ok := data["servers"].([]interface{}); ok {
 		for _, elem := range d {
 			if str, ok := elem.(string); ok {
@@ -513,52 +648,77 @@ func parseStatus(data map[string]interface{}) (st Status, err error) {
 			}
 		}
 	}
-
-	if len(st.Servers) == 0 {
-		return st, &omegleErr{"parseStatus", "failed to parse servers", ""}
-	}
 	return
 }
 
 // GetStatus gets status of omegle via http://[server].omegle.com/status
 func (o *Omegle) GetStatus() (st Status, err error) {
-	o.generateRandID()
-	resp, err := getRequest(o.buildURL(statusCmd), map[string]string{"randid": o.randid})
+	return o.GetStatusContext(context.Background())
+}
+
+// GetStatusContext is like GetStatus but aborts when ctx is done
+func (o *Omegle) GetStatusContext(ctx context.Context) (st Status, err error) {
+	return o.getStatus(ctx, o.Server)
+}
+
+// Get the status from the given server
+func (o *Omegle) getStatus(ctx context.Context, server string) (st Status, err error) {
+	resp, code, err := o.getRequest(ctx, "GetStatus", o.serverURL(server, statusCmd), map[string]string{"randid": o.generateRandID()})
 	if err != nil {
 		return Status{}, err
 	}
-	return convertAndParse(resp)
+	st, err = convertAndParse(resp)
+	if err != nil {
+		return Status{}, &Error{"GetStatus", err, resp, code}
+	}
+	return st, nil
 }
 
 // StopLookingForCommonLikes stops looking for strangers only interested in specified topics
 func (o *Omegle) StopLookingForCommonLikes() error {
+	return o.StopLookingForCommonLikesContext(context.Background())
+}
+
+// StopLookingForCommonLikesContext is like StopLookingForCommonLikes but aborts when ctx is done
+func (o *Omegle) StopLookingForCommonLikesContext(ctx context.Context) error {
+	return o.stopLookingForCommonLikes(ctx, o.getChat())
+}
+
+// Stop looking for common likes in the conversation with the given id
+func (o *Omegle) stopLookingForCommonLikes(ctx context.Context, c chat) error {
 	if len(o.Topics) == 0 {
-		return &omegleErr{"StopLookingForCommonLikes", "topic list is empty", ""}
-	}
-	if o.getID() == "" {
-		return &omegleErr{"StopLookingForCommonLikes", "id is empty", ""}
-	}
-	resp, err := postRequest(o.buildURL(stoplookingforcommonlikesCmd), map[string]string{"id": o.getID()})
-	if err != nil {
-		return err
+		return &Error{"StopLookingForCommonLikes", ErrNoTopics, "", 0}
 	}
-	if resp != "win" {
-		return &omegleErr{"StopLookingForCommonLikes", "returned something other than win", resp}
+	if c.id == "" {
+		return &Error{"StopLookingForCommonLikes", ErrNoSession, "", 0}
 	}
-	return nil
+	return o.command(ctx, "StopLookingForCommonLikes", c, stoplookingforcommonlikesCmd, map[string]string{})
 }
 
 // Recaptcha sends back the response to given challenge to omegle
 // Only to be used in case of recaptchaRequired or recaptchaRejected events
 func (o *Omegle) Recaptcha(challenge, response string) error {
-	if o.getID() == "" {
-		return &omegleErr{"Recaptcha", "id is empty", ""}
+	return o.RecaptchaContext(context.Background(), challenge, response)
+}
+
+// RecaptchaContext is like Recaptcha but aborts when ctx is done
+func (o *Omegle) RecaptchaContext(ctx context.Context, challenge, response string) error {
+	return o.recaptcha(ctx, o.getChat(), challenge, response)
+}
+
+// Answer a reCAPTCHA in the conversation with the given id
+func (o *Omegle) recaptcha(ctx context.Context, c chat, challenge, response string) error {
+	if c.id == "" {
+		return &Error{"Recaptcha", ErrNoSession, "", 0}
+	}
+	resp, code, err := o.postRequest(ctx, "Recaptcha", o.serverURL(c.server, recaptchaCmd), map[string]string{"id": c.id, "challenge": challenge, "response": response})
+	if err != nil {
+		return err
 	}
-	resp, err := postRequest(o.buildURL(recaptchaCmd), map[string]string{"id": o.getID(), "challenge": challenge, "response": response})
 	if resp == "fail" {
-		return &omegleErr{"Recaptcha", "returned \"fail\", expected something else", resp}
+		return &Error{"Recaptcha", ErrRecaptchaRejected, resp, code}
 	}
-	return err
+	return nil
 }
 
 // Saves the following constants used in LogEntry
@@ -582,18 +742,54 @@ type LogEntry struct {
 	Arg1, Arg2 string
 }
 
+// Fields returns the entry as it is shown in a log: the label, such as
+// "Stranger:", and the text, or just the text for DEF. It returns nil if Tp
+// is unknown
+func (e LogEntry) Fields() []string {
+	switch e.Tp {
+	case DEF:
+		return []string{e.Arg1}
+	case Q:
+		return []string{"Question to discuss:", e.Arg1}
+	case STR:
+		return []string{"Stranger:", e.Arg1}
+	case STR1:
+		return []string{"Stranger 1:", e.Arg1}
+	case STR2:
+		return []string{"Stranger 2:", e.Arg1}
+	case YOU:
+		return []string{"You:", e.Arg1}
+	case NORMAL:
+		return []string{e.Arg1, e.Arg2}
+	}
+	return nil
+}
+
 // Generate sends a request to generate a log file to omegle and returns the image link.
 func (o *Omegle) Generate(identdigests string, logs []LogEntry) (url string, err error) {
+	return o.GenerateContext(context.Background(), identdigests, logs)
+}
+
+// GenerateContext is like Generate but aborts when ctx is done
+func (o *Omegle) GenerateContext(ctx context.Context, identdigests string, logs []LogEntry) (url string, err error) {
+	return o.generate(ctx, o.getChat(), o.generateRandID(), identdigests, logs)
+}
+
+// Link to the picture in the answer to /generate. Mirrors of the log server
+// link to pictures on their own host
+var logLink = regexp.MustCompile(`https?://[^\s"'<>]+?\.png`)
+
+// Generate a log of the conversation with the given id
+func (o *Omegle) generate(ctx context.Context, c chat, randid, identdigests string, logs []LogEntry) (url string, err error) {
 	if strings.TrimSpace(identdigests) == "" {
-		return "", &omegleErr{"Generate", "identdigests is empty", ""}
+		return "", &Error{"Generate", ErrEmptyIdentDigests, "", 0}
 	}
-	if o.getID() == "" {
-		return "", &omegleErr{"Generate", "no conversation has been started (id == \"\")", ""}
+	if c.id == "" {
+		return "", &Error{"Generate", ErrNoSession, "", 0}
 	}
-	o.generateRandID()
 
 	params := map[string]string{}
-	params["randid"] = o.randid
+	params["randid"] = randid
 	params["identdigests"] = identdigests
 	params["host"] = "1"
 
@@ -607,23 +803,8 @@ func (o *Omegle) Generate(identdigests string, logs []LogEntry) (url string, err
 
 	logsSlice := [][]string{}
 	for _, val := range logs {
-		switch val.Tp {
-		case DEF:
-			logsSlice = append(logsSlice, []string{val.Arg1})
-		case Q:
-			logsSlice = append(logsSlice, []string{"Question to discuss:", val.Arg1})
-		case STR:
-			logsSlice = append(logsSlice, []string{"Stranger:", val.Arg1})
-		case STR1:
-			logsSlice = append(logsSlice, []string{"Stranger 1:", val.Arg1})
-		case STR2:
-			logsSlice = append(logsSlice, []string{"Stranger 2:", val.Arg1})
-		case YOU:
-			logsSlice = append(logsSlice, []string{"You:", val.Arg1})
-		case NORMAL:
-			logsSlice = append(logsSlice, []string{val.Arg1, val.Arg2})
-		default:
-			continue
+		if fields := val.Fields(); fields != nil {
+			logsSlice = append(logsSlice, fields)
 		}
 	}
 
@@ -633,15 +814,14 @@ func (o *Omegle) Generate(identdigests string, logs []LogEntry) (url string, err
 	}
 	params["log"] = string(logsStr)
 
-	resp, err := postRequest("http://logs.omegle.com/"+generateCmd, params)
+	resp, code, err := o.postRequest(ctx, "Generate", o.buildLogURL(generateCmd), params)
 	if err != nil {
 		return "", err
 	}
 
-	re := regexp.MustCompile(`http://l\.[Oo]megle\.com/.*\.png`)
-	link := re.FindString(resp)
+	link := logLink.FindString(resp)
 	if link == "" {
-		return "", &omegleErr{"Generate", "can't find link to log picture", resp}
+		return "", &Error{"Generate", unexpected("can't find link to log picture"), resp, code}
 	}
 	return link, nil
 }
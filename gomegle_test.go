@@ -1,10 +1,31 @@
 package gomegle
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"reflect"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/GiedriusS/gomegle/gomegletest"
 )
 
+// srv is the fake omegle server all tests run against
+var srv *gomegletest.Server
+
+func TestMain(m *testing.M) {
+	srv = gomegletest.NewServer()
+	defaultClient = &http.Client{Transport: srv.Transport()}
+	code := m.Run()
+	srv.Close()
+	os.Exit(code)
+}
+
 func TestGetID(t *testing.T) {
 	var o Omegle
 	err := o.GetID()
@@ -215,8 +236,8 @@ func TestGenerate(t *testing.T) {
 		tries++
 	}
 
-	if id == "" {
-		t.Error("no ident digest found")
+	if id != gomegletest.DefaultDigests {
+		t.Error("got wrong ident digests: ", id)
 	}
 
 	err = o.Disconnect()
@@ -231,6 +252,11 @@ func TestGenerate(t *testing.T) {
 		t.Error(err)
 	}
 	t.Log(url)
+
+	logs := srv.Logs()
+	if len(logs) == 0 || logs[len(logs)-1].Get("identdigests") != id {
+		t.Error("log was not sent to the server")
+	}
 }
 
 func TestBuildURL(t *testing.T) {
@@ -242,20 +268,145 @@ func TestBuildURL(t *testing.T) {
 	if o.buildURL("test") != "http://test.omegle.com/test" {
 		t.Error("got wrong URL")
 	}
+	if o.buildLogURL("generate") != "http://logs.omegle.com/generate" {
+		t.Error("got wrong log URL")
+	}
+
+	o.Endpoint = Endpoint{Scheme: "https", Host: "example.com", Port: 8443, Path: "/omegle/"}
+	if o.buildURL("test") != "https://test.example.com:8443/omegle/test" {
+		t.Error("got wrong URL: ", o.buildURL("test"))
+	}
+	o.Endpoint.ServerInPath = true
+	if o.buildURL("test") != "https://example.com:8443/omegle/test/test" {
+		t.Error("got wrong URL: ", o.buildURL("test"))
+	}
+	o.Server = ""
+	if o.buildURL("test") != "https://example.com:8443/omegle/test" {
+		t.Error("got wrong URL: ", o.buildURL("test"))
+	}
+
+	o.LogEndpoint = Endpoint{Host: "logs.example.com"}
+	if o.buildLogURL("generate") != "http://logs.example.com/generate" {
+		t.Error("got wrong log URL: ", o.buildLogURL("generate"))
+	}
+}
+
+func TestParseEndpoint(t *testing.T) {
+	e, err := ParseEndpoint("https://example.com:8080/omegle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e != (Endpoint{Scheme: "https", Host: "example.com", Port: 8080, Path: "/omegle"}) {
+		t.Error("parsed endpoint wrong: ", e)
+	}
+	_, err = ParseEndpoint("example.com")
+	if err == nil {
+		t.Error("expected err, got nil")
+	}
+	_, err = ParseEndpoint("http://example.com:port")
+	if err == nil {
+		t.Error("expected err, got nil")
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	e, err := ParseEndpoint(srv.URL + "/prefix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.ServerInPath = true
+
+	o := Omegle{Endpoint: e, LogEndpoint: e, Server: "front7"}
+	err = o.GetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat := srv.Lookup(o.getID())
+	if chat == nil || chat.Server != "front7" {
+		t.Error("chat was not started on the right server")
+	}
+	err = o.Disconnect()
+	if err != nil {
+		t.Error(err)
+	}
+	link, err := o.Generate(gomegletest.DefaultDigests, []LogEntry{{DEF, "gomegle", ""}})
+	if err != nil {
+		t.Error(err)
+	}
+	if !strings.HasPrefix(link, srv.URL+"/") {
+		t.Error("got a link to another host: ", link)
+	}
+
+	e, err = ParseEndpoint(srv.URL + "/prefix/{server}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.ServerInPath || e.Path != "/prefix" {
+		t.Error("parsed endpoint wrong: ", e)
+	}
 }
 
 func TestOmegleError(t *testing.T) {
-	var err omegleErr
+	var err Error
 	one := err.Error()
-	err.buf = "test"
+	err.Body = "test"
 	if one == err.Error() {
 		t.Error("got the same error twice")
 	}
 	two := err.Error()
-	err.method = "test"
+	err.Method = "test"
 	if two == err.Error() {
 		t.Error("got the same error twice")
 	}
+	three := err.Error()
+	err.StatusCode = 502
+	if three == err.Error() {
+		t.Error("got the same error twice")
+	}
+	err.Err = ErrUnexpectedResponse
+	if !errors.Is(&err, ErrUnexpectedResponse) {
+		t.Error("Error does not unwrap")
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	var o Omegle
+	if err := o.SendMessage("test"); !errors.Is(err, ErrNoSession) {
+		t.Error("expected ErrNoSession, got ", err)
+	}
+	if err := o.StopLookingForCommonLikes(); !errors.Is(err, ErrNoTopics) {
+		t.Error("expected ErrNoTopics, got ", err)
+	}
+	if _, err := o.Generate("", nil); !errors.Is(err, ErrEmptyIdentDigests) {
+		t.Error("expected ErrEmptyIdentDigests, got ", err)
+	}
+	if _, err := ParseEndpoint("omegle.com"); !errors.Is(err, ErrInvalidEndpoint) {
+		t.Error("expected ErrInvalidEndpoint, got ", err)
+	}
+
+	err := o.GetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.SendMessage(""); !errors.Is(err, ErrEmptyMessage) {
+		t.Error("expected ErrEmptyMessage, got ", err)
+	}
+	if err := o.Recaptcha("challenge", ""); !errors.Is(err, ErrRecaptchaRejected) {
+		t.Error("expected ErrRecaptchaRejected, got ", err)
+	}
+
+	err = o.Disconnect()
+	if err != nil {
+		t.Error(err)
+	}
+	err = o.Disconnect()
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatal("expected *Error, got ", err)
+	}
+	if e.Method != "Disconnect" || e.Body != "fail" || e.StatusCode != http.StatusOK || !errors.Is(e, ErrFailed) {
+		t.Errorf("got wrong error: %#v", e)
+	}
 }
 
 func TestDifferentModes(t *testing.T) {
@@ -298,3 +449,306 @@ func TestDifferentModes(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestConversation(t *testing.T) {
+	var o Omegle
+	err := o.GetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat := srv.Lookup(o.getID())
+	if chat == nil {
+		t.Fatal("chat was not started on the server")
+	}
+
+	err = o.SendMessage("hi")
+	if err != nil {
+		t.Error(err)
+	}
+	if msgs := chat.Messages(); len(msgs) != 1 || msgs[0] != "hi" {
+		t.Error("server got wrong messages: ", msgs)
+	}
+
+	chat.Send("hello")
+	chat.Disconnect()
+
+	got := map[Event]string{}
+	for tries := 0; tries < 10; tries++ {
+		st, msg, err := o.UpdateEvents()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range st {
+			if ev, ok := v.(Event); ok && len(msg[k]) != 0 {
+				got[ev] = msg[k][0]
+			} else if ok {
+				got[ev] = ""
+			}
+		}
+		if _, ok := got[DISCONNECTED]; ok {
+			break
+		}
+	}
+	if got[MESSAGE] != "hello" {
+		t.Error("did not get the message from the stranger")
+	}
+	if _, ok := got[CONNECTED]; !ok {
+		t.Error("did not get connected")
+	}
+	if _, ok := got[DISCONNECTED]; !ok {
+		t.Error("stranger did not disconnect")
+	}
+}
+
+// countingTransport counts the requests going through it
+type countingTransport struct {
+	base http.RoundTripper
+	mu   sync.Mutex
+	n    int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.n++
+	c.mu.Unlock()
+	return c.base.RoundTrip(req)
+}
+
+func TestClient(t *testing.T) {
+	rt := &countingTransport{base: srv.Transport()}
+	o := Omegle{Client: &http.Client{Transport: rt}}
+
+	err := o.GetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = o.UpdateEvents()
+	if err != nil {
+		t.Error(err)
+	}
+	err = o.SendMessage("test")
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = o.GetStatus()
+	if err != nil {
+		t.Error(err)
+	}
+	err = o.Disconnect()
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = o.Generate(gomegletest.DefaultDigests, []LogEntry{{DEF, "gomegle", ""}})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if rt.n != 6 {
+		t.Error("expected 6 requests through the client, got ", rt.n)
+	}
+}
+
+func TestContext(t *testing.T) {
+	var o Omegle
+	err := o.GetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := o.GetIDContext(ctx); err != context.Canceled {
+		t.Error("GetIDContext: expected context.Canceled, got ", err)
+	}
+	if err := o.ShowTypingContext(ctx); err != context.Canceled {
+		t.Error("ShowTypingContext: expected context.Canceled, got ", err)
+	}
+	if err := o.StopTypingContext(ctx); err != context.Canceled {
+		t.Error("StopTypingContext: expected context.Canceled, got ", err)
+	}
+	if err := o.SendMessageContext(ctx, "test"); err != context.Canceled {
+		t.Error("SendMessageContext: expected context.Canceled, got ", err)
+	}
+	if _, _, err := o.UpdateEventsContext(ctx); err != context.Canceled {
+		t.Error("UpdateEventsContext: expected context.Canceled, got ", err)
+	}
+	if _, err := o.GetStatusContext(ctx); err != context.Canceled {
+		t.Error("GetStatusContext: expected context.Canceled, got ", err)
+	}
+	if err := o.RecaptchaContext(ctx, "a", "b"); err != context.Canceled {
+		t.Error("RecaptchaContext: expected context.Canceled, got ", err)
+	}
+	if _, err := o.GenerateContext(ctx, "abcd", nil); err != context.Canceled {
+		t.Error("GenerateContext: expected context.Canceled, got ", err)
+	}
+	if err := o.DisconnectContext(ctx); err != context.Canceled {
+		t.Error("DisconnectContext: expected context.Canceled, got ", err)
+	}
+	o.Topics = []string{"test"}
+	if err := o.StopLookingForCommonLikesContext(ctx); err != context.Canceled {
+		t.Error("StopLookingForCommonLikesContext: expected context.Canceled, got ", err)
+	}
+
+	err = o.Disconnect()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestContextLongPoll(t *testing.T) {
+	slow := gomegletest.NewUnstartedServer()
+	slow.Manual = true
+	slow.PollTimeout = time.Minute
+	slow.Start()
+	defer slow.Close()
+
+	o := Omegle{Client: &http.Client{Transport: slow.Transport()}}
+	err := o.GetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fetch "waiting" so that the next poll blocks
+	_, _, err = o.UpdateEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err = o.UpdateEventsContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Error("expected context.DeadlineExceeded, got ", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("long poll was not aborted")
+	}
+}
+
+func TestPollEvents(t *testing.T) {
+	var o Omegle
+	_, err := o.PollEvents()
+	if err == nil {
+		t.Error("should have returned an error")
+	}
+
+	o.Topics = []string{"pizza", "go"}
+	err = o.GetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat := srv.Lookup(o.getID())
+	chat.Push("count", 1234)
+	chat.Push("spyMessage", "Stranger 1", "hi")
+	chat.Push("statusInfo", map[string]interface{}{"count": 10, "antinudeservers": []string{"a"},
+		"antinudepercent": 1, "spyeeQueueTime": 1, "spyQueueTime": 1, "timestamp": 1, "servers": []string{"front1"}})
+	chat.Push("unknownEvent")
+	chat.Send("hello")
+
+	events, err := o.PollEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []EventData{WAITING, CONNECTED, CommonLikesEvent{[]string{"pizza", "go"}},
+		IdentDigestsEvent{gomegletest.DefaultDigests}, CountEvent{1234}, SpyMessageEvent{"Stranger 1", "hi"},
+		StatusInfoEvent{Status{10, false, []string{"a"}, 1, 1, 1, 1, []string{"front1"}}}, MessageEvent{"hello"}}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got %#v, want %#v", events, want)
+	}
+	for i := range events {
+		if events[i].Type() != want[i].Type() {
+			t.Error("wrong type of event ", i)
+		}
+	}
+
+	err = o.Disconnect()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseEvents(t *testing.T) {
+	events, err := parseEvents("null")
+	if err != nil || len(events) != 0 {
+		t.Error("expected no events and no error")
+	}
+	_, err = parseEvents("{}")
+	if err == nil {
+		t.Error("expected err, got nil")
+	}
+	_, err = parseEvents(`[["somethingNew"]]`)
+	if err == nil {
+		t.Error("expected err, got nil")
+	}
+	events, err = parseEvents(`[["error", "bad"], ["recaptchaRequired", "abc"], ["partnerCollege", "ktu.edu"]]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []EventData{ErrorEvent{"bad"}, RecaptchaRequiredEvent{"abc"}, PartnerCollegeEvent{"ktu.edu"}}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got %#v, want %#v", events, want)
+	}
+}
+
+func TestResponseErrors(t *testing.T) {
+	faulty := gomegletest.NewServer()
+	defer faulty.Close()
+	o := Omegle{Client: &http.Client{Transport: faulty.Transport()}}
+	err := o.GetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		code int
+		body string
+		want error
+	}{
+		{http.StatusBadGateway, "<html>502 Bad Gateway</html>", ErrHTTPStatus},
+		{http.StatusTooManyRequests, "slow down", ErrRateLimited},
+		{http.StatusOK, "", ErrEmptyResponse},
+		{http.StatusOK, "fail", ErrFailed},
+		{http.StatusOK, "lose", ErrUnexpectedResponse},
+	}
+	for _, test := range tests {
+		faulty.ClearFaults()
+		faulty.Fault("", "", test.code, test.body)
+
+		for name, fn := range map[string]func() error{
+			"ShowTyping":  o.ShowTyping,
+			"StopTyping":  o.StopTyping,
+			"SendMessage": func() error { return o.SendMessage("test") },
+			"Disconnect":  o.Disconnect,
+		} {
+			err := fn()
+			var e *Error
+			if !errors.As(err, &e) || !errors.Is(err, test.want) {
+				t.Errorf("%s with %d %q: expected %v, got %v", name, test.code, test.body, test.want, err)
+				continue
+			}
+			if e.Method != name || e.StatusCode != test.code || e.Body != test.body {
+				t.Errorf("%s: got wrong error: %#v", name, e)
+			}
+		}
+	}
+
+	faulty.ClearFaults()
+	faulty.Fault("", "start", http.StatusOK, "<html>maintenance</html>")
+	if err := o.GetID(); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Error("expected ErrUnexpectedResponse, got ", err)
+	}
+	faulty.Fault("", "", http.StatusServiceUnavailable, "down")
+	if _, err := o.PollEvents(); !errors.Is(err, ErrHTTPStatus) {
+		t.Error("expected ErrHTTPStatus, got ", err)
+	}
+	if _, err := o.GetStatus(); !errors.Is(err, ErrHTTPStatus) {
+		t.Error("expected ErrHTTPStatus, got ", err)
+	}
+	if err := o.Recaptcha("a", "b"); !errors.Is(err, ErrHTTPStatus) {
+		t.Error("expected ErrHTTPStatus, got ", err)
+	}
+	if _, err := o.Generate("abcd", nil); !errors.Is(err, ErrHTTPStatus) {
+		t.Error("expected ErrHTTPStatus, got ", err)
+	}
+}
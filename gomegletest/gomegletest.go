@@ -0,0 +1,572 @@
+// Package gomegletest provides an in-process stand-in for the Omegle servers
+// so that code using gomegle can be tested without reaching omegle.com
+package gomegletest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default values used by NewServer
+const (
+	DefaultPollTimeout = 50 * time.Millisecond
+	DefaultDigests     = "1a2b3c4d,5e6f7a8b,9c0d1e2f,3a4b5c6d"
+	DefaultQuestion    = "What is your favourite colour?"
+)
+
+// Status mirrors the JSON object served on /status
+type Status struct {
+	Count           int      `json:"count"`
+	ForceUnmon      bool     `json:"force_unmon"`
+	Antinudeservers []string `json:"antinudeservers"`
+	Antinudepercent float64  `json:"antinudepercent"`
+	SpyQueueTime    float64  `json:"spyQueueTime"`
+	SpyeeQueueTime  float64  `json:"spyeeQueueTime"`
+	Timestamp       float64  `json:"timestamp"`
+	Servers         []string `json:"servers"`
+}
+
+// Request is a single request received by the server
+type Request struct {
+	Server string     // Front server the request was addressed to, "" if none
+	Cmd    string     // Command, such as "start" or "send"
+	Form   url.Values // Query and form parameters
+}
+
+// Server is a fake Omegle server. Every chat started on it is connected to a
+// stranger which is driven by the test through Chat
+type Server struct {
+	URL string // Base URL of the server, such as http://127.0.0.1:1234
+
+	// Manual makes new chats stay in the "waiting" state until
+	// Chat.Connect is called
+	Manual bool
+	// PollTimeout is how long /events blocks when there is nothing to return
+	PollTimeout time.Duration
+	// Digests is sent in the identDigests event of every chat
+	Digests string
+
+	srv      *httptest.Server
+	mu       sync.Mutex
+	random   *rand.Rand
+	status   Status
+	chats    map[string]*Chat
+	order    []*Chat
+	added    chan struct{} // Closed and replaced every time a chat is started
+	requests []Request
+	logs     []url.Values
+	faults   []fault
+}
+
+// fault is a canned response set up with Fault
+type fault struct {
+	server, cmd string
+	code        int
+	body        string
+}
+
+// NewServer starts and returns a new fake server. The caller should call
+// Close when finished, to shut it down
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a new fake server that is not started yet so
+// that its exported fields can be changed before calling Start
+func NewUnstartedServer() *Server {
+	s := &Server{
+		PollTimeout: DefaultPollTimeout,
+		Digests:     DefaultDigests,
+		random:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		chats:       map[string]*Chat{},
+		added:       make(chan struct{}),
+		status: Status{
+			Count:           1000,
+			Antinudeservers: []string{"waw1.omegle.com"},
+			Antinudepercent: 1.0,
+			SpyQueueTime:    0.5,
+			SpyeeQueueTime:  1.5,
+			Timestamp:       float64(time.Now().Unix()),
+			Servers:         []string{"front1", "front2"},
+		},
+	}
+	s.srv = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Start starts a server from NewUnstartedServer
+func (s *Server) Start() {
+	s.srv.Start()
+	s.URL = s.srv.URL
+}
+
+// Close shuts down the server and blocks until all outstanding requests
+// on this server have completed
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Transport returns a RoundTripper which sends every request to the server
+// no matter which URL it was made for. The original host is kept in the
+// Host header so that front servers such as front1.omegle.com can still be
+// told apart
+func (s *Server) Transport() http.RoundTripper {
+	return &rewriter{base: s.srv.Client().Transport, target: s.srv.Listener.Addr().String()}
+}
+
+// rewriter is the RoundTripper returned by Transport
+type rewriter struct {
+	base   http.RoundTripper
+	target string
+}
+
+// RoundTrip sends req to the fake server
+func (r *rewriter) RoundTrip(req *http.Request) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	out.URL.Scheme = "http"
+	out.URL.Host = r.target
+	out.Host = req.URL.Host
+	return r.base.RoundTrip(out)
+}
+
+// Fault makes the server answer every request for cmd on the given front
+// server with code and body instead of handling it. An empty server or cmd
+// matches all of them. A code of 429 also sets a Retry-After header
+func (s *Server) Fault(server, cmd string, code int, body string) {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	s.faults = append(s.faults, fault{server, cmd, code, body})
+}
+
+// ClearFaults removes everything set up with Fault
+func (s *Server) ClearFaults() {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	s.faults = nil
+}
+
+// SetStatus replaces the object served on /status
+func (s *Server) SetStatus(st Status) {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	s.status = st
+}
+
+// Requests returns all requests received so far
+func (s *Server) Requests() []Request {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Logs returns the parameters of every /generate request received so far
+func (s *Server) Logs() []url.Values {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	return append([]url.Values(nil), s.logs...)
+}
+
+// Chats returns all chats in the order they were started
+func (s *Server) Chats() []*Chat {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	return append([]*Chat(nil), s.order...)
+}
+
+// Lookup returns the chat with the given id or nil if there is none
+func (s *Server) Lookup(id string) *Chat {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	return s.chats[id]
+}
+
+// WaitChat waits until at least n+1 chats were started and returns the n-th
+// one (counting from 0). It returns nil if that did not happen in time
+func (s *Server) WaitChat(n int, timeout time.Duration) *Chat {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		if len(s.order) > n {
+			c := s.order[n]
+			s.mu.Unlock()
+			return c
+		}
+		added := s.added
+		s.mu.Unlock()
+
+		select {
+		case <-added:
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// Split the request into the front server and the command. The server is
+// taken either from a leading path element (/front1/start) or from the
+// first label of an *.omegle.com host
+func splitRequest(r *http.Request) (server, cmd string) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	cmd = parts[len(parts)-1]
+	if len(parts) > 1 {
+		return parts[len(parts)-2], cmd
+	}
+
+	host := r.Host
+	if i := strings.LastIndex(host, ":"); i != -1 {
+		host = host[:i]
+	}
+	if strings.HasSuffix(host, ".omegle.com") {
+		server = strings.TrimSuffix(host, ".omegle.com")
+		if server == "logs" || server == "www" {
+			server = ""
+		}
+	}
+	return server, cmd
+}
+
+// Handle every request sent to the server
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	server, cmd := splitRequest(r)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{server, cmd, r.Form})
+	for _, f := range s.faults {
+		if (f.server == "" || f.server == server) && (f.cmd == "" || f.cmd == cmd) {
+			s.mu.Unlock()
+			if f.code == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			w.WriteHeader(f.code)
+			fmt.Fprint(w, f.body)
+			return
+		}
+	}
+	s.mu.Unlock()
+
+	switch cmd {
+	case "start":
+		s.serveStart(w, r, server)
+	case "events":
+		s.serveEvents(w, r)
+	case "send":
+		s.serveChat(w, r, func(c *Chat) bool {
+			msg := r.Form.Get("msg")
+			if msg == "" || !c.connected || c.ended {
+				return false
+			}
+			c.received = append(c.received, msg)
+			return true
+		})
+	case "typing", "stoppedtyping":
+		s.serveChat(w, r, func(c *Chat) bool {
+			if c.ended {
+				return false
+			}
+			c.typing = cmd == "typing"
+			return true
+		})
+	case "disconnect":
+		s.serveChat(w, r, func(c *Chat) bool {
+			if c.ended {
+				return false
+			}
+			c.ended = true
+			c.wakeLocked()
+			return true
+		})
+	case "stoplookingforcommonlikes":
+		s.serveChat(w, r, func(c *Chat) bool {
+			if c.ended {
+				return false
+			}
+			c.stoppedLooking = true
+			if !c.connected {
+				c.connectLocked(false)
+			}
+			return true
+		})
+	case "recaptcha":
+		s.serveChat(w, r, func(c *Chat) bool {
+			return !c.ended && r.Form.Get("response") != ""
+		})
+	case "status":
+		s.mu.Lock()
+		st := s.status
+		s.mu.Unlock()
+		writeJSON(w, st)
+	case "generate":
+		s.mu.Lock()
+		s.logs = append(s.logs, r.Form)
+		n := len(s.logs)
+		s.mu.Unlock()
+		host := r.Host
+		if host == "logs.omegle.com" {
+			host = "l.omegle.com"
+		}
+		fmt.Fprintf(w, "<html><body><img src=\"http://%s/%08x.png\"></body></html>", host, n)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Write v as JSON to w
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// Generate a new chat id in the same format omegle uses
+func (s *Server) newIDLocked(server string) string {
+	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	if server == "" {
+		server = "central2"
+	}
+	for {
+		id := server + ":"
+		for i := 0; i < 30; i++ {
+			id += string(chars[s.random.Intn(len(chars))])
+		}
+		if _, ok := s.chats[id]; !ok {
+			return id
+		}
+	}
+}
+
+// Handle /start by creating a new chat
+func (s *Server) serveStart(w http.ResponseWriter, r *http.Request, server string) {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+
+	c := &Chat{
+		ID:     s.newIDLocked(server),
+		Server: server,
+		Params: r.Form,
+		srv:    s,
+		wake:   make(chan struct{}),
+	}
+	if t := r.Form.Get("topics"); t != "" {
+		if err := json.Unmarshal([]byte(t), &c.Topics); err != nil {
+			http.Error(w, "invalid topics", http.StatusBadRequest)
+			return
+		}
+	}
+	s.chats[c.ID] = c
+	s.order = append(s.order, c)
+	close(s.added)
+	s.added = make(chan struct{})
+
+	c.pushLocked("waiting")
+	if !s.Manual {
+		c.connectLocked(true)
+	}
+	writeJSON(w, c.ID)
+}
+
+// Handle /events by long polling until there are events for the chat
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	c := s.Lookup(r.Form.Get("id"))
+	if c == nil {
+		fmt.Fprint(w, "null")
+		return
+	}
+
+	timer := time.NewTimer(s.PollTimeout)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		if len(c.events) != 0 {
+			events := c.events
+			c.events = nil
+			s.mu.Unlock()
+			writeJSON(w, events)
+			return
+		}
+		wake, ended := c.wake, c.ended
+		s.mu.Unlock()
+
+		if ended {
+			fmt.Fprint(w, "null")
+			return
+		}
+		select {
+		case <-wake:
+		case <-timer.C:
+			fmt.Fprint(w, "null")
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Handle a command on an existing chat. fn is called with the server
+// locked and reports whether the command succeeded
+func (s *Server) serveChat(w http.ResponseWriter, r *http.Request, fn func(c *Chat) bool) {
+	s.mu.Lock()
+	c := s.chats[r.Form.Get("id")]
+	ok := c != nil && fn(c)
+	s.mu.Unlock()
+
+	if ok {
+		fmt.Fprint(w, "win")
+	} else {
+		fmt.Fprint(w, "fail")
+	}
+}
+
+// Chat is one conversation on the server, controlled from the side of the
+// stranger
+type Chat struct {
+	ID     string     // Id handed out by /start
+	Server string     // Front server the chat was started on, "" if none
+	Params url.Values // Parameters sent to /start
+	Topics []string   // Topics sent to /start
+
+	srv            *Server
+	events         [][]interface{} // Events not yet fetched by the client
+	wake           chan struct{}   // Closed and replaced whenever events change
+	received       []string        // Messages sent by the client
+	typing         bool            // Whether the client is typing
+	connected      bool            // Whether a stranger was connected
+	ended          bool            // Whether the client disconnected
+	stoppedLooking bool            // Whether /stoplookingforcommonlikes was called
+}
+
+// Wake up anyone waiting for events
+func (c *Chat) wakeLocked() {
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
+
+// Queue an event for the client
+func (c *Chat) pushLocked(name string, args ...interface{}) {
+	c.events = append(c.events, append([]interface{}{name}, args...))
+	c.wakeLocked()
+}
+
+// Queue the events of a stranger joining the chat
+func (c *Chat) connectLocked(likes bool) {
+	c.connected = true
+	c.pushLocked("connected")
+
+	switch {
+	case c.Params.Get("ask") != "":
+		c.pushLocked("question", c.Params.Get("ask"))
+	case c.Params.Get("wantsspy") != "":
+		c.pushLocked("question", DefaultQuestion)
+	default:
+		if likes && len(c.Topics) != 0 {
+			c.pushLocked("commonLikes", c.Topics)
+		}
+		if college := c.Params.Get("college"); college != "" {
+			c.pushLocked("partnerCollege", college)
+		}
+	}
+	c.pushLocked("identDigests", c.srv.Digests)
+}
+
+// Connect connects a stranger to a chat started while the server was in
+// Manual mode. Common likes are reported if the chat had topics
+func (c *Chat) Connect() {
+	defer c.srv.mu.Unlock()
+	c.srv.mu.Lock()
+	if !c.connected {
+		c.connectLocked(true)
+	}
+}
+
+// Push queues an arbitrary event, such as Push("count", 1234)
+func (c *Chat) Push(name string, args ...interface{}) {
+	defer c.srv.mu.Unlock()
+	c.srv.mu.Lock()
+	c.pushLocked(name, args...)
+}
+
+// Send sends a message from the stranger to the client
+func (c *Chat) Send(msg string) {
+	c.Push("gotMessage", msg)
+}
+
+// Typing shows to the client that the stranger is typing
+func (c *Chat) Typing() {
+	c.Push("typing")
+}
+
+// StoppedTyping shows to the client that the stranger stopped typing
+func (c *Chat) StoppedTyping() {
+	c.Push("stoppedTyping")
+}
+
+// Disconnect makes the stranger leave the chat
+func (c *Chat) Disconnect() {
+	c.Push("strangerDisconnected")
+}
+
+// Messages returns the messages the client sent so far
+func (c *Chat) Messages() []string {
+	defer c.srv.mu.Unlock()
+	c.srv.mu.Lock()
+	return append([]string(nil), c.received...)
+}
+
+// WaitMessages waits until the client sent at least n messages and returns
+// them. It returns what was sent so far if that did not happen in time
+func (c *Chat) WaitMessages(n int, timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for {
+		msgs := c.Messages()
+		if len(msgs) >= n || time.Now().After(deadline) {
+			return msgs
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// IsTyping reports whether the client is currently shown as typing
+func (c *Chat) IsTyping() bool {
+	defer c.srv.mu.Unlock()
+	c.srv.mu.Lock()
+	return c.typing
+}
+
+// Connected reports whether a stranger was connected to the chat
+func (c *Chat) Connected() bool {
+	defer c.srv.mu.Unlock()
+	c.srv.mu.Lock()
+	return c.connected
+}
+
+// StoppedLooking reports whether the client asked to stop looking for
+// strangers with common likes
+func (c *Chat) StoppedLooking() bool {
+	defer c.srv.mu.Unlock()
+	c.srv.mu.Lock()
+	return c.stoppedLooking
+}
+
+// Ended reports whether the client disconnected from the chat
+func (c *Chat) Ended() bool {
+	defer c.srv.mu.Unlock()
+	c.srv.mu.Lock()
+	return c.ended
+}
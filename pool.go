@@ -0,0 +1,179 @@
+package gomegle
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Strategy tells a ServerPool in which order to try the front servers
+type Strategy int
+
+// Strategies for picking a front server
+const (
+	Random        Strategy = iota // Any server, picked at random every time
+	RoundRobin                    // Every server in turn
+	LowestLatency                 // The server that answered /status the fastest
+)
+
+// String returns a human readable name of the strategy
+func (s Strategy) String() string {
+	switch s {
+	case Random:
+		return "random"
+	case RoundRobin:
+		return "round robin"
+	case LowestLatency:
+		return "lowest latency"
+	}
+	return "unknown strategy"
+}
+
+// Default values used when the fields of ServerPool are zero
+const (
+	DefaultPoolRefresh = 5 * time.Minute
+	DefaultFailTimeout = time.Minute
+)
+
+// ServerPool picks the front server conversations are started on from the
+// list in Status.Servers. When /start fails on a server the next one is
+// tried, and servers that failed recently are only tried after the others.
+// Set Omegle.Pool to use it. The zero value is ready to use and the same pool
+// can be shared by many Omegle values and sessions
+type ServerPool struct {
+	Strategy    Strategy      // Optional, Random if not set
+	Refresh     time.Duration // Optional, fetch /status again after this long, DefaultPoolRefresh if 0
+	FailTimeout time.Duration // Optional, how long a failed server is tried last, DefaultFailTimeout if 0
+
+	m        sync.Mutex
+	servers  []string
+	fetched  time.Time                // When servers were fetched
+	latency  map[string]time.Duration // How long /status took on each server
+	next     int                      // Where RoundRobin starts next time
+	failures map[string]time.Time     // When each server last failed
+}
+
+// Servers returns the front servers known to the pool, fetching them from
+// /status on the main server if they are not known yet
+func (p *ServerPool) Servers(ctx context.Context, o *Omegle) ([]string, error) {
+	if err := p.refresh(ctx, o); err != nil {
+		return nil, err
+	}
+	defer p.m.Unlock()
+	p.m.Lock()
+	return append([]string(nil), p.servers...), nil
+}
+
+// Fetch the list of servers if it is missing or stale. A failure is only
+// reported if there is no list to fall back on
+func (p *ServerPool) refresh(ctx context.Context, o *Omegle) error {
+	refresh := p.Refresh
+	if refresh == 0 {
+		refresh = DefaultPoolRefresh
+	}
+	p.m.Lock()
+	fresh := len(p.servers) != 0 && time.Since(p.fetched) < refresh
+	have := len(p.servers) != 0
+	p.m.Unlock()
+	if fresh {
+		return nil
+	}
+
+	st, err := o.getStatus(ctx, "")
+	if err != nil {
+		if have && ctx.Err() == nil {
+			return nil
+		}
+		return err
+	}
+
+	var latency map[string]time.Duration
+	if p.Strategy == LowestLatency {
+		latency = map[string]time.Duration{}
+		for _, server := range st.Servers {
+			begin := time.Now()
+			if _, err := o.getStatus(ctx, server); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				continue
+			}
+			latency[server] = time.Since(begin)
+		}
+	}
+
+	defer p.m.Unlock()
+	p.m.Lock()
+	p.servers = append([]string(nil), st.Servers...)
+	p.fetched = time.Now()
+	p.latency = latency
+	return nil
+}
+
+// candidates returns the servers in the order they should be tried
+func (p *ServerPool) candidates(ctx context.Context, o *Omegle) ([]string, error) {
+	if err := p.refresh(ctx, o); err != nil {
+		return nil, err
+	}
+
+	defer p.m.Unlock()
+	p.m.Lock()
+	servers := append([]string(nil), p.servers...)
+	if len(servers) == 0 {
+		return []string{""}, nil // Nothing to pick from, use the main server
+	}
+	switch p.Strategy {
+	case RoundRobin:
+		n := p.next % len(servers)
+		servers = append(append([]string(nil), servers[n:]...), servers[:n]...)
+		p.next = n + 1
+	case LowestLatency:
+		sort.SliceStable(servers, func(i, j int) bool {
+			li, iok := p.latency[servers[i]]
+			lj, jok := p.latency[servers[j]]
+			if iok != jok {
+				return iok // Servers that did not answer go last
+			}
+			return li < lj
+		})
+	default:
+		randomM.Lock()
+		random.Shuffle(len(servers), func(i, j int) {
+			servers[i], servers[j] = servers[j], servers[i]
+		})
+		randomM.Unlock()
+	}
+
+	timeout := p.FailTimeout
+	if timeout == 0 {
+		timeout = DefaultFailTimeout
+	}
+	sort.SliceStable(servers, func(i, j int) bool {
+		return !p.recentlyFailed(servers[i], timeout) && p.recentlyFailed(servers[j], timeout)
+	})
+	return servers, nil
+}
+
+// Whether server failed less than timeout ago, p.m must be held
+func (p *ServerPool) recentlyFailed(server string, timeout time.Duration) bool {
+	at, ok := p.failures[server]
+	return ok && time.Since(at) < timeout
+}
+
+// failed records that a request to server failed
+func (p *ServerPool) failed(server string) {
+	defer p.m.Unlock()
+	p.m.Lock()
+	if p.failures == nil {
+		p.failures = map[string]time.Time{}
+	}
+	p.failures[server] = time.Now()
+}
+
+// succeeded records that a conversation was started on server
+func (p *ServerPool) succeeded(server string) {
+	defer p.m.Unlock()
+	p.m.Lock()
+	delete(p.failures, server)
+}
@@ -0,0 +1,114 @@
+package gomegle
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/GiedriusS/gomegle/gomegletest"
+)
+
+func TestPoolStrategies(t *testing.T) {
+	ctx := context.Background()
+	o := &Omegle{}
+
+	p := &ServerPool{Strategy: RoundRobin}
+	for i, want := range []string{"front1", "front2", "front1"} {
+		servers, err := p.candidates(ctx, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(servers) != 2 || servers[0] != want {
+			t.Errorf("round %d: got %v, want %s first", i, servers, want)
+		}
+	}
+
+	for _, strategy := range []Strategy{Random, LowestLatency} {
+		p := &ServerPool{Strategy: strategy}
+		servers, err := p.candidates(ctx, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(servers) != 2 || servers[0] == servers[1] {
+			t.Errorf("%v: got %v", strategy, servers)
+		}
+	}
+
+	p = &ServerPool{Strategy: RoundRobin}
+	p.failed("front1")
+	for i := 0; i < 2; i++ {
+		servers, err := p.candidates(ctx, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if servers[len(servers)-1] != "front1" {
+			t.Error("failed server was not tried last: ", servers)
+		}
+	}
+}
+
+func TestPoolFailover(t *testing.T) {
+	fake := gomegletest.NewServer()
+	defer fake.Close()
+	fake.Fault("front1", "start", http.StatusBadGateway, "")
+
+	o := Omegle{
+		Client: &http.Client{Transport: fake.Transport()},
+		Pool:   &ServerPool{Strategy: RoundRobin},
+	}
+	for i := 0; i < 2; i++ {
+		s, err := o.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Server() != "front2" {
+			t.Error("conversation was started on ", s.Server())
+		}
+		s.Close()
+	}
+
+	fake.Fault("front2", "start", http.StatusBadGateway, "")
+	if _, err := o.Start(context.Background()); !errors.Is(err, ErrHTTPStatus) {
+		t.Error("expected ErrHTTPStatus when every server fails, got ", err)
+	}
+}
+
+func TestPoolEventsFailover(t *testing.T) {
+	fake := gomegletest.NewServer()
+	defer fake.Close()
+	fake.Fault("front1", "events", http.StatusBadGateway, "")
+
+	o := Omegle{
+		Client:    &http.Client{Transport: fake.Transport()},
+		Pool:      &ServerPool{Strategy: RoundRobin},
+		Reconnect: &ReconnectPolicy{NetworkFailure: true, MinBackoff: time.Millisecond},
+	}
+	s, err := o.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Server() != "front1" {
+		t.Fatal("expected to start on front1, got ", s.Server())
+	}
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case ev, ok := <-s.Events():
+			if !ok {
+				t.Fatal("events channel closed: ", s.Err())
+			}
+			if ev == CONNECTED {
+				if s.Server() != "front2" {
+					t.Error("reconnected to ", s.Server())
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("did not fail over to front2")
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package gomegle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Race starts a session for every contender at once, keeps the one that
+// finds a stranger first and disconnects the others. Use it to find someone
+// faster when looking for niche topics, by racing several sets of topics or
+// languages
+type Race struct {
+	Contenders []*Omegle // Configurations to start the sessions with
+	// Optional, if not 0 then the race goes on for this long after the first
+	// stranger connects and the session sharing the most common likes with
+	// its stranger wins, the earliest to connect if tied
+	Window time.Duration
+}
+
+// raceUpdate is what a contender reports to Race.Run
+type raceUpdate struct {
+	i         int
+	connected bool // A stranger connected
+	likes     int  // How many common likes were found, if not 0
+	ended     bool // The session ended
+}
+
+// contender is a session taking part in a race
+type contender struct {
+	s    *Session
+	seen chan []EventData // The events read by watch, sent when it returns
+}
+
+// Read the events of the session and report what matters to the race until
+// the session ends or stop is closed
+func (c *contender) watch(i int, updates chan<- raceUpdate, stop <-chan struct{}) {
+	var seen []EventData
+	defer func() { c.seen <- seen }()
+
+	events := c.s.Events()
+	for {
+		var u raceUpdate
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				u = raceUpdate{i: i, ended: true}
+				break
+			}
+			seen = append(seen, ev)
+			switch ev := ev.(type) {
+			case CommonLikesEvent:
+				u = raceUpdate{i: i, likes: len(ev.Topics)}
+			case Event:
+				if ev != CONNECTED {
+					continue
+				}
+				u = raceUpdate{i: i, connected: true}
+			default:
+				continue
+			}
+		case <-stop:
+			return
+		}
+
+		select {
+		case updates <- u:
+		case <-stop:
+			return
+		}
+		if u.ended {
+			return
+		}
+	}
+}
+
+// Run starts the sessions and returns the winner once it is picked. Its
+// events are delivered from the start, including those read during the
+// race. If no session could be started the first error is returned, and if
+// all of them ended before a stranger connected the error wraps
+// ErrNoStranger. The losers are closed before Run returns
+func (r *Race) Run(ctx context.Context) (*Session, error) {
+	startCtx, cancelStarts := context.WithCancel(ctx)
+	defer cancelStarts()
+
+	type startResult struct {
+		i   int
+		s   *Session
+		err error
+	}
+	starts := make(chan startResult)
+	for i, o := range r.Contenders {
+		go func(i int, o *Omegle) {
+			s, err := o.Start(startCtx)
+			starts <- startResult{i, s, err}
+		}(i, o)
+	}
+
+	contenders := make([]*contender, len(r.Contenders))
+	updates := make(chan raceUpdate)
+	stop := make(chan struct{})
+	likes := make([]int, len(r.Contenders))
+	ended := make([]bool, len(r.Contenders))
+	var connected []int // In the order they connected
+	pending, alive, started := len(r.Contenders), 0, 0
+	var startErr error // Of the first session that could not be started
+	var window <-chan time.Time
+	var err error
+	winner := -1
+
+	for winner < 0 && err == nil {
+		if pending == 0 && alive == 0 {
+			err = startErr
+			if started != 0 || err == nil {
+				err = &Error{"Race", ErrNoStranger, "", 0}
+			}
+			break
+		}
+
+		select {
+		case res := <-starts:
+			pending--
+			if res.err != nil {
+				if startErr == nil {
+					startErr = res.err
+				}
+				continue
+			}
+			contenders[res.i] = &contender{res.s, make(chan []EventData, 1)}
+			started++
+			alive++
+			go contenders[res.i].watch(res.i, updates, stop)
+		case u := <-updates:
+			switch {
+			case u.ended:
+				ended[u.i] = true
+				alive--
+			case u.connected:
+				connected = append(connected, u.i)
+				if r.Window == 0 {
+					winner = u.i
+				} else if window == nil {
+					window = time.After(r.Window)
+				}
+			case u.likes != 0:
+				likes[u.i] = u.likes
+			}
+		case <-window:
+			window = nil
+			for _, i := range connected {
+				if !ended[i] && (winner < 0 || likes[i] > likes[winner]) {
+					winner = i
+				}
+			}
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	close(stop)
+	cancelStarts()
+	for ; pending > 0; pending-- {
+		if res := <-starts; res.s != nil {
+			contenders[res.i] = &contender{res.s, nil}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range contenders {
+		if c == nil || i == winner {
+			continue
+		}
+		wg.Add(1)
+		go func(s *Session) {
+			defer wg.Done()
+			s.Close()
+		}(c.s)
+	}
+	wg.Wait()
+	if winner < 0 {
+		return nil, err
+	}
+
+	s := contenders[winner].s
+	s.replay(<-contenders[winner].seen)
+	return s, nil
+}
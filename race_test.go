@@ -0,0 +1,118 @@
+package gomegle
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GiedriusS/gomegle/gomegletest"
+)
+
+// Race configurations with the given topics on a fake server on which
+// strangers never connect by themselves
+func manualRace(t *testing.T, topics ...[]string) (*gomegletest.Server, *Race) {
+	manual := gomegletest.NewUnstartedServer()
+	manual.Manual = true
+	manual.Start()
+	t.Cleanup(manual.Close)
+
+	r := &Race{}
+	for _, tp := range topics {
+		r.Contenders = append(r.Contenders, &Omegle{Client: &http.Client{Transport: manual.Transport()}, Topics: tp})
+	}
+	return manual, r
+}
+
+// Run the race in the background
+func runRace(r *Race) (chan *Session, chan error) {
+	won, failed := make(chan *Session, 1), make(chan error, 1)
+	go func() {
+		s, err := r.Run(context.Background())
+		if err != nil {
+			failed <- err
+			return
+		}
+		won <- s
+	}()
+	return won, failed
+}
+
+// Get the chat with the given topics
+func chatWithTopics(t *testing.T, srv *gomegletest.Server, n int, topics []string) *gomegletest.Chat {
+	for i := 0; i < n; i++ {
+		if c := srv.WaitChat(i, time.Second); c != nil && reflect.DeepEqual(c.Topics, topics) {
+			return c
+		}
+	}
+	t.Fatal("no chat with topics ", topics)
+	return nil
+}
+
+func TestRace(t *testing.T) {
+	manual, r := manualRace(t, []string{"cats"}, []string{"dogs"}, []string{"birds"})
+	won, failed := runRace(r)
+
+	chatWithTopics(t, manual, 3, []string{"dogs"}).Connect()
+	var s *Session
+	select {
+	case s = <-won:
+	case err := <-failed:
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, c := range manual.Chats() {
+		if (c.ID == s.ID()) == c.Ended() {
+			t.Errorf("chat with topics %v: ended %v", c.Topics, c.Ended())
+		}
+	}
+	var got []EventData
+	for ev := range s.Events() {
+		if got = append(got, ev); ev.Type() == IDENTDIGESTS {
+			break
+		}
+	}
+	want := []EventData{WAITING, CONNECTED, CommonLikesEvent{[]string{"dogs"}}, IdentDigestsEvent{gomegletest.DefaultDigests}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+}
+
+func TestRaceWindow(t *testing.T) {
+	manual, r := manualRace(t, []string{"cats"}, []string{"cats", "dogs"})
+	r.Window = 200 * time.Millisecond
+	won, failed := runRace(r)
+
+	chatWithTopics(t, manual, 2, []string{"cats"}).Connect()
+	time.Sleep(50 * time.Millisecond)
+	best := chatWithTopics(t, manual, 2, []string{"cats", "dogs"})
+	best.Connect()
+	select {
+	case s := <-won:
+		defer s.Close()
+		if s.ID() != best.ID {
+			t.Error("the session with fewer common likes won")
+		}
+	case err := <-failed:
+		t.Fatal(err)
+	}
+}
+
+func TestRaceNoStranger(t *testing.T) {
+	manual, r := manualRace(t, []string{"cats"}, []string{"dogs"})
+	_, failed := runRace(r)
+	for i := 0; i < 2; i++ {
+		manual.WaitChat(i, time.Second).Push("strangerDisconnected")
+	}
+	if err := <-failed; !errors.Is(err, ErrNoStranger) {
+		t.Error("expected ErrNoStranger, got ", err)
+	}
+
+	manual.Fault("", "start", http.StatusBadGateway, "")
+	if _, err := r.Run(context.Background()); !errors.Is(err, ErrHTTPStatus) {
+		t.Error("expected the start error, got ", err)
+	}
+}
@@ -0,0 +1,105 @@
+package gomegle
+
+import "time"
+
+// Reason tells why a conversation ended
+type Reason int
+
+// Reasons passed to ReconnectPolicy.OnRetry
+const (
+	StrangerLeft   Reason = iota // The stranger disconnected (DISCONNECTED or SPYDISCONNECTED)
+	NetworkFailure               // Requests kept failing or the connection died (CONNECTIONDIED)
+	ServerError                  // Omegle sent an error event (ERROR)
+)
+
+// String returns a human readable name of the reason
+func (r Reason) String() string {
+	switch r {
+	case StrangerLeft:
+		return "stranger left"
+	case NetworkFailure:
+		return "network failure"
+	case ServerError:
+		return "server error"
+	}
+	return "unknown reason"
+}
+
+// Default values used when the fields of ReconnectPolicy are zero
+const (
+	DefaultMinBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// Retry describes one attempt to start a new conversation
+type Retry struct {
+	Attempt int           // 1 for the first attempt after a conversation ended
+	Reason  Reason        // Why the last conversation ended or the last attempt failed
+	Err     error         // The error behind Reason, if there is one
+	Delay   time.Duration // How long the session waits before starting
+}
+
+// ReconnectPolicy tells a Session when and how to start a new conversation
+// after the current one ended. The same policy can be shared by many sessions
+type ReconnectPolicy struct {
+	StrangerLeft   bool // Reconnect when the stranger leaves
+	NetworkFailure bool // Reconnect when requests fail or the connection dies
+	ServerError    bool // Reconnect when omegle sends an error event
+
+	MaxAttempts int           // Optional, give up after this many attempts in a row, 0 means never
+	MinBackoff  time.Duration // Optional, wait before the first attempt, DefaultMinBackoff if 0
+	MaxBackoff  time.Duration // Optional, the wait doubles up to this, DefaultMaxBackoff if 0
+	Jitter      float64       // Optional, random fraction (0 to 1) by which every wait is shortened
+
+	// Optional, called before every attempt. Sessions call it from their
+	// own goroutines so it has to be safe for concurrent use
+	OnRetry func(Retry)
+}
+
+// allows reports whether the policy reconnects after a conversation ended
+// for the given reason with attempt attempts already made
+func (p *ReconnectPolicy) allows(r Reason, attempt int) bool {
+	if p == nil || (p.MaxAttempts != 0 && attempt >= p.MaxAttempts) {
+		return false
+	}
+	switch r {
+	case StrangerLeft:
+		return p.StrangerLeft
+	case NetworkFailure:
+		return p.NetworkFailure
+	case ServerError:
+		return p.ServerError
+	}
+	return false
+}
+
+// Backoff returns how long to wait before the given attempt, counted from 1.
+// A nil policy waits as long as the zero value
+func (p *ReconnectPolicy) Backoff(attempt int) time.Duration {
+	if p == nil {
+		p = &ReconnectPolicy{}
+	}
+	min, max := p.MinBackoff, p.MaxBackoff
+	if min <= 0 {
+		min = DefaultMinBackoff
+	}
+	if max <= 0 {
+		max = DefaultMaxBackoff
+	}
+
+	d := min
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	if p.Jitter > 0 {
+		randomM.Lock()
+		f := random.Float64()
+		randomM.Unlock()
+		d -= time.Duration(float64(d) * p.Jitter * f)
+	}
+	return d
+}
@@ -0,0 +1,140 @@
+package gomegle
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GiedriusS/gomegle/gomegletest"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	p := &ReconnectPolicy{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		if d := p.Backoff(i + 1); d != w*time.Millisecond {
+			t.Errorf("attempt %d: got %v, want %v", i+1, d, w*time.Millisecond)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.Backoff(2); d > 200*time.Millisecond || d < 100*time.Millisecond {
+			t.Error("jitter out of range: ", d)
+		}
+	}
+
+	var empty ReconnectPolicy
+	if empty.Backoff(1) != DefaultMinBackoff || empty.Backoff(100) != DefaultMaxBackoff {
+		t.Error("defaults were not used")
+	}
+}
+
+func TestReconnectAllows(t *testing.T) {
+	var p *ReconnectPolicy
+	if p.allows(StrangerLeft, 0) {
+		t.Error("nil policy must not reconnect")
+	}
+	p = &ReconnectPolicy{StrangerLeft: true, ServerError: true, MaxAttempts: 2}
+	if !p.allows(StrangerLeft, 1) || !p.allows(ServerError, 0) {
+		t.Error("policy should have allowed reconnecting")
+	}
+	if p.allows(NetworkFailure, 0) {
+		t.Error("policy should not reconnect on network failures")
+	}
+	if p.allows(StrangerLeft, 2) {
+		t.Error("policy should have run out of attempts")
+	}
+}
+
+func TestSessionReconnect(t *testing.T) {
+	var m sync.Mutex
+	var retries []Retry
+	o := Omegle{Reconnect: &ReconnectPolicy{StrangerLeft: true, MinBackoff: time.Millisecond,
+		OnRetry: func(r Retry) {
+			m.Lock()
+			retries = append(retries, r)
+			m.Unlock()
+		}}}
+	s, err := o.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	first := s.ID()
+	srv.Lookup(first).Disconnect()
+
+	connected := 0
+	timeout := time.After(5 * time.Second)
+	for connected < 2 {
+		select {
+		case ev, ok := <-s.Events():
+			if !ok {
+				t.Fatal("events channel closed: ", s.Err())
+			}
+			if ev == CONNECTED {
+				connected++
+			}
+		case <-timeout:
+			t.Fatal("did not reconnect")
+		}
+	}
+
+	if s.ID() == first {
+		t.Error("session kept the id of the old conversation")
+	}
+	m.Lock()
+	if len(retries) != 1 || retries[0].Reason != StrangerLeft || retries[0].Attempt != 1 {
+		t.Error("OnRetry got wrong retries: ", retries)
+	}
+	m.Unlock()
+
+	chat := srv.Lookup(s.ID())
+	if err := s.Close(); err != nil {
+		t.Error(err)
+	}
+	if !chat.Ended() {
+		t.Error("Close did not disconnect from the new conversation")
+	}
+}
+
+func TestSessionReconnectGivesUp(t *testing.T) {
+	manual := gomegletest.NewUnstartedServer()
+	manual.Manual = true
+	manual.Start()
+	defer manual.Close()
+
+	reasons := make(chan Reason, 10)
+	o := Omegle{
+		Client: &http.Client{Transport: manual.Transport()},
+		Reconnect: &ReconnectPolicy{ServerError: true, MaxAttempts: 1, MinBackoff: time.Millisecond,
+			OnRetry: func(r Retry) { reasons <- r.Reason }},
+	}
+	s, err := o.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	manual.WaitChat(0, time.Second).Push("error", "first")
+	second := manual.WaitChat(1, 5*time.Second)
+	if second == nil {
+		t.Fatal("did not reconnect after an error")
+	}
+	second.Push("error", "second")
+
+	collect(t, s)
+	if !errors.Is(s.Err(), ErrServerError) {
+		t.Error("expected the session to end with ErrServerError, got ", s.Err())
+	}
+	if len(reasons) != 1 || <-reasons != ServerError {
+		t.Error("expected exactly one retry because of a server error")
+	}
+	if len(manual.Chats()) != 2 {
+		t.Error("expected 2 conversations, got ", len(manual.Chats()))
+	}
+}
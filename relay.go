@@ -0,0 +1,411 @@
+package gomegle
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Side is one of the two strangers bridged by a Relay
+type Side int
+
+// The two sides of a Relay
+const (
+	SideA Side = iota
+	SideB
+)
+
+// String returns the label of the side used in transcripts
+func (s Side) String() string {
+	if s == SideA {
+		return "Stranger 1"
+	}
+	return "Stranger 2"
+}
+
+// Other returns the opposite side
+func (s Side) Other() Side {
+	return 1 - s
+}
+
+// Action tells what happened to a message on its way through a Relay
+type Action int
+
+// Actions recorded in RelayEntry
+const (
+	Passed   Action = iota // Forwarded as it was, also used for everything but messages
+	Edited                 // Forwarded with a different text
+	Dropped                // Not forwarded at all
+	Injected               // Not sent by a stranger but with Relay.Inject
+)
+
+// String returns a human readable name of the action
+func (a Action) String() string {
+	switch a {
+	case Passed:
+		return "passed"
+	case Edited:
+		return "edited"
+	case Dropped:
+		return "dropped"
+	case Injected:
+		return "injected"
+	}
+	return "unknown action"
+}
+
+// RelayMessage is a message on its way from one stranger to the other
+type RelayMessage struct {
+	From Side
+	Text string
+}
+
+// Interceptor decides what happens to messages before they are forwarded.
+// Intercept returns the text to forward in place of m.Text and false if the
+// message must be dropped. It is called with the messages of each side in
+// order, but may be called for both sides at the same time. ctx is done when
+// the relay stops
+type Interceptor interface {
+	Intercept(ctx context.Context, m RelayMessage) (text string, forward bool)
+}
+
+// InterceptorFunc lets an ordinary function be used as an Interceptor
+type InterceptorFunc func(ctx context.Context, m RelayMessage) (text string, forward bool)
+
+// Intercept calls f(ctx, m)
+func (f InterceptorFunc) Intercept(ctx context.Context, m RelayMessage) (text string, forward bool) {
+	return f(ctx, m)
+}
+
+// RelayEntry is one line of the combined transcript of a Relay
+type RelayEntry struct {
+	Time  time.Time
+	Side  Side      // The stranger the event came from
+	Event EventData // What happened
+	// What the interceptor did to the message in Event. Every message is
+	// recorded as Passed when it arrives, edited and dropped ones get a
+	// second entry once the interceptor is done with them
+	Action Action
+}
+
+// String formats the entry as a transcript line such as "Stranger 1: hi"
+func (e RelayEntry) String() string {
+	switch ev := e.Event.(type) {
+	case MessageEvent:
+		if e.Action != Passed {
+			return fmt.Sprintf("%v (%v): %s", e.Side, e.Action, ev.Text)
+		}
+		return fmt.Sprintf("%v: %s", e.Side, ev.Text)
+	case CommonLikesEvent:
+		return fmt.Sprintf("%v likes %v", e.Side, ev.Topics)
+	case ErrorEvent:
+		return fmt.Sprintf("%v error: %s", e.Side, ev.Text)
+	}
+	switch e.Event.Type() {
+	case WAITING:
+		return fmt.Sprintf("%v: looking for a stranger", e.Side)
+	case CONNECTED:
+		return fmt.Sprintf("%v connected", e.Side)
+	case DISCONNECTED:
+		return fmt.Sprintf("%v disconnected", e.Side)
+	case TYPING:
+		return fmt.Sprintf("%v is typing", e.Side)
+	case STOPPEDTYPING:
+		return fmt.Sprintf("%v stopped typing", e.Side)
+	case CONNECTIONDIED:
+		return fmt.Sprintf("%v lost the connection", e.Side)
+	}
+	return fmt.Sprintf("%v: event %d", e.Side, e.Event.Type())
+}
+
+// Relay bridges two strangers so that each of them thinks they are talking
+// to the other one. Messages and typing notifications are forwarded between
+// two sessions and everything that happens is recorded in a transcript.
+// Set the exported fields and call Run
+type Relay struct {
+	A *Omegle // Configuration of the first stranger's conversation
+	B *Omegle // Optional, configuration of the second one, A if nil
+	// Optional, if true a new stranger is found for a side when its stranger
+	// leaves instead of ending the relay. Messages written to the stranger
+	// who left that are still waiting for the Interceptor are dropped
+	Rematch bool
+	// Optional, called for every entry added to the transcript. With an
+	// Interceptor or Inject it may be called from several goroutines at once
+	OnEntry func(RelayEntry)
+	// Optional, if not nil every message goes through it before it is
+	// forwarded
+	Interceptor Interceptor
+
+	m          sync.Mutex
+	sessions   [2]*Session
+	transcript []RelayEntry
+}
+
+// sideEvent is an event received on one side, ev is nil once the side's
+// conversation is over
+type sideEvent struct {
+	side Side
+	ev   EventData
+	s    *Session // The session ev was received on
+}
+
+// relayed is a message on its way through the interceptor
+type relayed struct {
+	RelayMessage
+	conv int // The conversation of the receiving side it was written to
+}
+
+// Session returns the current session of side, nil if there is none
+func (r *Relay) Session(side Side) *Session {
+	defer r.m.Unlock()
+	r.m.Lock()
+	return r.sessions[side]
+}
+
+// Transcript returns the entries recorded so far
+func (r *Relay) Transcript() []RelayEntry {
+	defer r.m.Unlock()
+	r.m.Lock()
+	return append([]RelayEntry(nil), r.transcript...)
+}
+
+// Get the configuration of side. Sessions must not reconnect on their own,
+// the relay decides what happens when a stranger leaves
+func (r *Relay) config(side Side) *Omegle {
+	o := r.A
+	if side == SideB && r.B != nil {
+		o = r.B
+	}
+	conf := o.Config()
+	conf.Reconnect = nil
+	return conf
+}
+
+// Start a conversation for side and forward its events to events until ctx
+// is done
+func (r *Relay) start(ctx context.Context, side Side, events chan<- sideEvent) error {
+	s, err := r.config(side).Start(ctx)
+	if err != nil {
+		return err
+	}
+	r.m.Lock()
+	r.sessions[side] = s
+	r.m.Unlock()
+
+	go func() {
+		for ev := range s.Events() {
+			select {
+			case events <- sideEvent{side, ev, s}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case events <- sideEvent{side, nil, s}:
+		case <-ctx.Done():
+		}
+	}()
+	return nil
+}
+
+// Add an entry to the transcript
+func (r *Relay) record(side Side, ev EventData, action Action) {
+	entry := RelayEntry{time.Now(), side, ev, action}
+	r.m.Lock()
+	r.transcript = append(r.transcript, entry)
+	r.m.Unlock()
+	if r.OnEntry != nil {
+		r.OnEntry(entry)
+	}
+}
+
+// Close the sessions of both sides
+func (r *Relay) closeAll() {
+	for _, side := range []Side{SideA, SideB} {
+		if s := r.Session(side); s != nil {
+			s.Close()
+		}
+	}
+}
+
+// Run starts a conversation for each side and relays between them until
+// ctx is done or, unless Rematch is set, one of the strangers leaves. Both
+// conversations are disconnected when Run returns. The error of the side
+// that ended the relay is returned, nil if its stranger just left
+func (r *Relay) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events := make(chan sideEvent)
+	defer r.closeAll()
+
+	for _, side := range []Side{SideA, SideB} {
+		if err := r.start(ctx, side, events); err != nil {
+			return err
+		}
+	}
+
+	// Messages that passed the interceptor
+	forwarded := make(chan relayed)
+	var intercept [2]chan relayed
+	if r.Interceptor != nil {
+		for _, side := range []Side{SideA, SideB} {
+			intercept[side] = make(chan relayed)
+			go r.intercept(ctx, intercept[side], forwarded)
+		}
+	}
+	// Messages waiting for the interceptor, by the side they came from. The
+	// queue is not bounded so that a stranger flooding a side while the
+	// interceptor takes its time cannot stall the relay
+	var queued [2][]relayed
+	var conv [2]int // Counts the conversations of each side
+
+	var connected [2]bool
+	var pending [2][]string // Messages waiting for the side to connect
+	deliver := func(to Side, text string) error {
+		if !connected[to] {
+			pending[to] = append(pending[to], text)
+			return nil
+		}
+		return r.Session(to).SendMessageContext(ctx, text)
+	}
+
+	for {
+		// Only offer the interceptors a message if one is queued
+		var next [2]relayed
+		var toIntercept [2]chan<- relayed
+		for _, side := range []Side{SideA, SideB} {
+			if len(queued[side]) != 0 {
+				next[side], toIntercept[side] = queued[side][0], intercept[side]
+			}
+		}
+
+		var se sideEvent
+		select {
+		case se = <-events:
+		case toIntercept[SideA] <- next[SideA]:
+			queued[SideA] = queued[SideA][1:]
+			continue
+		case toIntercept[SideB] <- next[SideB]:
+			queued[SideB] = queued[SideB][1:]
+			continue
+		case m := <-forwarded:
+			to := m.From.Other()
+			if m.conv != conv[to] {
+				// Written to a stranger who has been replaced since
+				r.record(m.From, MessageEvent{m.Text}, Dropped)
+				continue
+			}
+			if err := deliver(to, m.Text); err != nil && ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if se.s != r.Session(se.side) {
+			continue // Left over from a conversation that was replaced
+		}
+		to := se.side.Other()
+
+		if se.ev == nil {
+			err := se.s.Err()
+			if !r.Rematch || ctx.Err() != nil {
+				return err
+			}
+			se.s.Close()
+			connected[se.side] = false
+			pending[se.side] = nil
+			conv[se.side]++
+			for _, m := range queued[to] {
+				r.record(m.From, MessageEvent{m.Text}, Dropped)
+			}
+			queued[to] = nil
+			if err := r.start(ctx, se.side, events); err != nil {
+				return err
+			}
+			continue
+		}
+		r.record(se.side, se.ev, Passed)
+
+		var err error
+		switch ev := se.ev.(type) {
+		case MessageEvent:
+			if r.Interceptor == nil {
+				err = deliver(to, ev.Text)
+				break
+			}
+			queued[se.side] = append(queued[se.side], relayed{RelayMessage{se.side, ev.Text}, conv[to]})
+		case Event:
+			switch ev {
+			case CONNECTED:
+				connected[se.side] = true
+				for _, msg := range pending[se.side] {
+					if err = se.s.SendMessageContext(ctx, msg); err != nil {
+						break
+					}
+				}
+				pending[se.side] = nil
+			case TYPING:
+				if connected[to] {
+					err = r.Session(to).ShowTypingContext(ctx)
+				}
+			case STOPPEDTYPING:
+				if connected[to] {
+					err = r.Session(to).StopTypingContext(ctx)
+				}
+			}
+		}
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// A failed forward usually means the other stranger just left, which
+		// shows up as the end of their conversation shortly
+	}
+}
+
+// Pass the messages from in through the interceptor and send the ones that
+// should be forwarded, with their new text, to out
+func (r *Relay) intercept(ctx context.Context, in <-chan relayed, out chan<- relayed) {
+	for {
+		var m relayed
+		select {
+		case m = <-in:
+		case <-ctx.Done():
+			return
+		}
+
+		text, forward := r.Interceptor.Intercept(ctx, m.RelayMessage)
+		if ctx.Err() != nil {
+			return
+		}
+		switch {
+		case !forward:
+			r.record(m.From, MessageEvent{m.Text}, Dropped)
+			continue
+		case text != m.Text:
+			r.record(m.From, MessageEvent{text}, Edited)
+		}
+
+		select {
+		case out <- relayed{RelayMessage{m.From, text}, m.conv}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Inject sends text to the stranger on side to as if the other stranger had
+// written it. It can be called from any goroutine while Run is running
+func (r *Relay) Inject(ctx context.Context, to Side, text string) error {
+	s := r.Session(to)
+	if s == nil {
+		return &Error{"Inject", ErrNoSession, "", 0}
+	}
+	if err := s.SendMessageContext(ctx, text); err != nil {
+		return err
+	}
+	r.record(to.Other(), MessageEvent{text}, Injected)
+	return nil
+}
@@ -0,0 +1,260 @@
+package gomegle
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GiedriusS/gomegle/gomegletest"
+)
+
+// Run r against a fresh fake server in the background
+func startRelay(t *testing.T, r *Relay) (*gomegletest.Server, chan error) {
+	fake := gomegletest.NewServer()
+	t.Cleanup(fake.Close)
+	r.A = &Omegle{Client: &http.Client{Transport: fake.Transport()}}
+
+	done := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	go func() { done <- r.Run(ctx) }()
+	return fake, done
+}
+
+func TestRelay(t *testing.T) {
+	r := &Relay{}
+	fake, done := startRelay(t, r)
+
+	a := fake.WaitChat(0, 5*time.Second)
+	b := fake.WaitChat(1, 5*time.Second)
+	if a == nil || b == nil {
+		t.Fatal("relay did not start two conversations")
+	}
+
+	a.Send("hi from a")
+	if got := b.WaitMessages(1, 5*time.Second); !reflect.DeepEqual(got, []string{"hi from a"}) {
+		t.Error("b got ", got)
+	}
+	b.Send("hi from b")
+	if got := a.WaitMessages(1, 5*time.Second); !reflect.DeepEqual(got, []string{"hi from b"}) {
+		t.Error("a got ", got)
+	}
+
+	a.Typing()
+	deadline := time.Now().Add(5 * time.Second)
+	for !b.IsTyping() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !b.IsTyping() {
+		t.Error("typing was not forwarded")
+	}
+
+	a.Disconnect()
+	select {
+	case err := <-done:
+		done <- err
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay did not end when a stranger left")
+	}
+	if !b.Ended() {
+		t.Error("relay did not disconnect the other stranger")
+	}
+
+	var lines []string
+	for _, e := range r.Transcript() {
+		if _, ok := e.Event.(MessageEvent); ok {
+			lines = append(lines, e.String())
+		}
+	}
+	want := []string{"Stranger 1: hi from a", "Stranger 2: hi from b"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("got transcript %q, want %q", lines, want)
+	}
+}
+
+func TestRelayRematch(t *testing.T) {
+	r := &Relay{Rematch: true}
+	fake, _ := startRelay(t, r)
+
+	if fake.WaitChat(1, 5*time.Second) == nil {
+		t.Fatal("relay did not start two conversations")
+	}
+	fake.Chats()[0].Disconnect()
+	c := fake.WaitChat(2, 5*time.Second)
+	if c == nil {
+		t.Fatal("relay did not find a new stranger")
+	}
+	if fake.Chats()[1].Ended() {
+		t.Error("the other stranger was disconnected")
+	}
+
+	// Wait for the new stranger to be relayed to before talking
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Session(SideA).ID() != c.ID && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Send("hello")
+	if got := fake.Chats()[1].WaitMessages(1, 5*time.Second); !reflect.DeepEqual(got, []string{"hello"}) {
+		t.Error("got ", got)
+	}
+}
+
+func TestRelayIntercept(t *testing.T) {
+	r := &Relay{Interceptor: InterceptorFunc(func(ctx context.Context, m RelayMessage) (string, bool) {
+		switch m.Text {
+		case "drop me":
+			return "", false
+		case "edit me":
+			return "edited", true
+		}
+		return m.Text, true
+	})}
+	fake, _ := startRelay(t, r)
+
+	a := fake.WaitChat(0, 5*time.Second)
+	b := fake.WaitChat(1, 5*time.Second)
+	if a == nil || b == nil {
+		t.Fatal("relay did not start two conversations")
+	}
+
+	a.Send("drop me")
+	a.Send("edit me")
+	a.Send("pass me")
+	want := []string{"edited", "pass me"}
+	if got := b.WaitMessages(2, 5*time.Second); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Session(SideB) == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := r.Inject(context.Background(), SideB, "injected"); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, "injected")
+	if got := b.WaitMessages(3, 5*time.Second); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	actions := map[Action]int{}
+	for _, e := range r.Transcript() {
+		actions[e.Action]++
+	}
+	if actions[Dropped] != 1 || actions[Edited] != 1 || actions[Injected] != 1 {
+		t.Error("wrong actions in the transcript: ", actions)
+	}
+}
+
+// Wait until the relay received n messages from side
+func waitReceived(r *Relay, side Side, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		got := 0
+		for _, e := range r.Transcript() {
+			if _, ok := e.Event.(MessageEvent); ok && e.Side == side && e.Action == Passed {
+				got++
+			}
+		}
+		if got >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// An interceptor that holds the messages of a side until release is closed
+func holdSide(side Side, release chan struct{}) Interceptor {
+	return InterceptorFunc(func(ctx context.Context, m RelayMessage) (string, bool) {
+		if m.From == side {
+			select {
+			case <-release:
+			case <-ctx.Done():
+			}
+		}
+		return m.Text, true
+	})
+}
+
+func TestRelayInterceptFlood(t *testing.T) {
+	release := make(chan struct{})
+	r := &Relay{Interceptor: holdSide(SideA, release)}
+	fake, _ := startRelay(t, r)
+
+	a := fake.WaitChat(0, 5*time.Second)
+	b := fake.WaitChat(1, 5*time.Second)
+	if a == nil || b == nil {
+		t.Fatal("relay did not start two conversations")
+	}
+
+	var want []string
+	for i := 0; i < 200; i++ {
+		msg := fmt.Sprint("flood ", i)
+		a.Send(msg)
+		want = append(want, msg)
+	}
+	waitReceived(r, SideA, len(want))
+	b.Send("still there?")
+	if got := a.WaitMessages(1, 5*time.Second); !reflect.DeepEqual(got, []string{"still there?"}) {
+		t.Fatal("the relay stalled while the interceptor was busy, got ", got)
+	}
+
+	close(release)
+	if got := b.WaitMessages(len(want), 5*time.Second); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %d messages, want %d in order", len(got), len(want))
+	}
+}
+
+func TestRelayRematchDropsQueued(t *testing.T) {
+	release := make(chan struct{})
+	r := &Relay{Rematch: true, Interceptor: holdSide(SideB, release)}
+	fake, _ := startRelay(t, r)
+
+	a := fake.WaitChat(0, 5*time.Second)
+	b := fake.WaitChat(1, 5*time.Second)
+	if a == nil || b == nil {
+		t.Fatal("relay did not start two conversations")
+	}
+	for i := 0; i < 3; i++ {
+		b.Send("for the first stranger")
+	}
+	waitReceived(r, SideB, 3)
+
+	a.Disconnect()
+	c := fake.WaitChat(2, 5*time.Second)
+	if c == nil {
+		t.Fatal("relay did not find a new stranger")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Session(SideA).ID() != c.ID && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	b.Send("for the second stranger")
+	if got := c.WaitMessages(1, 5*time.Second); !reflect.DeepEqual(got, []string{"for the second stranger"}) {
+		t.Error("got ", got)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := c.Messages(); len(got) != 1 {
+		t.Error("the new stranger got the messages of the old one: ", got)
+	}
+
+	dropped := 0
+	for _, e := range r.Transcript() {
+		if e.Action == Dropped {
+			dropped++
+		}
+	}
+	if dropped != 3 {
+		t.Errorf("got %d dropped messages, want 3", dropped)
+	}
+}
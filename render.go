@@ -0,0 +1,302 @@
+package gomegle
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// Layout of rendered logs, in pixels
+const (
+	renderWidth  = 640              // Width of the image
+	renderMargin = 16               // Space around the log
+	renderScale  = 2                // Every dot of a glyph is this many pixels
+	renderCellW  = 6 * renderScale  // Width of a character, the glyph and a gap
+	renderLineH  = 11 * renderScale // Height of a line of text
+	renderGap    = 4 * renderScale  // Space between entries
+	renderPad    = 4 * renderScale  // Space between the question box and its text
+	renderCols   = (renderWidth - 2*renderMargin) / renderCellW
+	renderQCols  = (renderWidth - 2*renderMargin - 2*renderPad) / renderCellW
+	renderAscent = 7 * renderScale // Height of a glyph above the baseline
+)
+
+// Colours of rendered logs, the same as in WriteHTML
+var (
+	renderBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	renderText       = color.RGBA{0x00, 0x00, 0x00, 0xff}
+	renderGray       = color.RGBA{0x55, 0x55, 0x55, 0xff}
+	renderRed        = color.RGBA{0xff, 0x00, 0x00, 0xff}
+	renderBlue       = color.RGBA{0x00, 0x00, 0xff, 0xff}
+	renderQText      = color.RGBA{0x1a, 0x4f, 0xa3, 0xff}
+	renderQFill      = color.RGBA{0xe8, 0xf0, 0xfd, 0xff}
+	renderQBorder    = color.RGBA{0x8a, 0xb4, 0xf8, 0xff}
+)
+
+// font5x7 has a 5x7 glyph for every printable ASCII character starting with
+// the space. Every byte is a column from left to right, bit 0 is the top row
+var font5x7 = [95][5]byte{
+	{0x00, 0x00, 0x00, 0x00, 0x00}, {0x00, 0x00, 0x5f, 0x00, 0x00}, {0x00, 0x07, 0x00, 0x07, 0x00}, // ' ' ! "
+	{0x14, 0x7f, 0x14, 0x7f, 0x14}, {0x24, 0x2a, 0x7f, 0x2a, 0x12}, {0x23, 0x13, 0x08, 0x64, 0x62}, // # $ %
+	{0x36, 0x49, 0x56, 0x20, 0x50}, {0x00, 0x00, 0x07, 0x00, 0x00}, {0x00, 0x1c, 0x22, 0x41, 0x00}, // & ' (
+	{0x00, 0x41, 0x22, 0x1c, 0x00}, {0x14, 0x08, 0x3e, 0x08, 0x14}, {0x08, 0x08, 0x3e, 0x08, 0x08}, // ) * +
+	{0x00, 0x50, 0x30, 0x00, 0x00}, {0x08, 0x08, 0x08, 0x08, 0x08}, {0x00, 0x60, 0x60, 0x00, 0x00}, // , - .
+	{0x20, 0x10, 0x08, 0x04, 0x02}, {0x3e, 0x51, 0x49, 0x45, 0x3e}, {0x00, 0x42, 0x7f, 0x40, 0x00}, // / 0 1
+	{0x42, 0x61, 0x51, 0x49, 0x46}, {0x21, 0x41, 0x45, 0x4b, 0x31}, {0x18, 0x14, 0x12, 0x7f, 0x10}, // 2 3 4
+	{0x27, 0x45, 0x45, 0x45, 0x39}, {0x3c, 0x4a, 0x49, 0x49, 0x30}, {0x01, 0x71, 0x09, 0x05, 0x03}, // 5 6 7
+	{0x36, 0x49, 0x49, 0x49, 0x36}, {0x06, 0x49, 0x49, 0x29, 0x1e}, {0x00, 0x36, 0x36, 0x00, 0x00}, // 8 9 :
+	{0x00, 0x56, 0x36, 0x00, 0x00}, {0x08, 0x14, 0x22, 0x41, 0x00}, {0x14, 0x14, 0x14, 0x14, 0x14}, // ; < =
+	{0x00, 0x41, 0x22, 0x14, 0x08}, {0x02, 0x01, 0x51, 0x09, 0x06}, {0x32, 0x49, 0x79, 0x41, 0x3e}, // > ? @
+	{0x7e, 0x11, 0x11, 0x11, 0x7e}, {0x7f, 0x49, 0x49, 0x49, 0x36}, {0x3e, 0x41, 0x41, 0x41, 0x22}, // A B C
+	{0x7f, 0x41, 0x41, 0x22, 0x1c}, {0x7f, 0x49, 0x49, 0x49, 0x41}, {0x7f, 0x09, 0x09, 0x09, 0x01}, // D E F
+	{0x3e, 0x41, 0x49, 0x49, 0x7a}, {0x7f, 0x08, 0x08, 0x08, 0x7f}, {0x00, 0x41, 0x7f, 0x41, 0x00}, // G H I
+	{0x20, 0x40, 0x41, 0x3f, 0x01}, {0x7f, 0x08, 0x14, 0x22, 0x41}, {0x7f, 0x40, 0x40, 0x40, 0x40}, // J K L
+	{0x7f, 0x02, 0x0c, 0x02, 0x7f}, {0x7f, 0x04, 0x08, 0x10, 0x7f}, {0x3e, 0x41, 0x41, 0x41, 0x3e}, // M N O
+	{0x7f, 0x09, 0x09, 0x09, 0x06}, {0x3e, 0x41, 0x51, 0x21, 0x5e}, {0x7f, 0x09, 0x19, 0x29, 0x46}, // P Q R
+	{0x46, 0x49, 0x49, 0x49, 0x31}, {0x01, 0x01, 0x7f, 0x01, 0x01}, {0x3f, 0x40, 0x40, 0x40, 0x3f}, // S T U
+	{0x1f, 0x20, 0x40, 0x20, 0x1f}, {0x3f, 0x40, 0x38, 0x40, 0x3f}, {0x63, 0x14, 0x08, 0x14, 0x63}, // V W X
+	{0x07, 0x08, 0x70, 0x08, 0x07}, {0x61, 0x51, 0x49, 0x45, 0x43}, {0x00, 0x7f, 0x41, 0x41, 0x00}, // Y Z [
+	{0x02, 0x04, 0x08, 0x10, 0x20}, {0x00, 0x41, 0x41, 0x7f, 0x00}, {0x04, 0x02, 0x01, 0x02, 0x04}, // \ ] ^
+	{0x40, 0x40, 0x40, 0x40, 0x40}, {0x00, 0x01, 0x02, 0x04, 0x00}, {0x20, 0x54, 0x54, 0x54, 0x78}, // _ ` a
+	{0x7f, 0x48, 0x44, 0x44, 0x38}, {0x38, 0x44, 0x44, 0x44, 0x20}, {0x38, 0x44, 0x44, 0x48, 0x7f}, // b c d
+	{0x38, 0x54, 0x54, 0x54, 0x18}, {0x08, 0x7e, 0x09, 0x01, 0x02}, {0x0c, 0x52, 0x52, 0x52, 0x3e}, // e f g
+	{0x7f, 0x08, 0x04, 0x04, 0x78}, {0x00, 0x44, 0x7d, 0x40, 0x00}, {0x20, 0x40, 0x44, 0x3d, 0x00}, // h i j
+	{0x7f, 0x10, 0x28, 0x44, 0x00}, {0x00, 0x41, 0x7f, 0x40, 0x00}, {0x7c, 0x04, 0x18, 0x04, 0x78}, // k l m
+	{0x7c, 0x08, 0x04, 0x04, 0x78}, {0x38, 0x44, 0x44, 0x44, 0x38}, {0x7c, 0x14, 0x14, 0x14, 0x08}, // n o p
+	{0x08, 0x14, 0x14, 0x18, 0x7c}, {0x7c, 0x08, 0x04, 0x04, 0x08}, {0x48, 0x54, 0x54, 0x54, 0x20}, // q r s
+	{0x04, 0x3f, 0x44, 0x40, 0x20}, {0x3c, 0x40, 0x40, 0x20, 0x7c}, {0x1c, 0x20, 0x40, 0x20, 0x1c}, // t u v
+	{0x3c, 0x40, 0x30, 0x40, 0x3c}, {0x44, 0x28, 0x10, 0x28, 0x44}, {0x0c, 0x50, 0x50, 0x50, 0x3c}, // w x y
+	{0x44, 0x64, 0x54, 0x4c, 0x44}, {0x00, 0x08, 0x36, 0x41, 0x00}, {0x00, 0x00, 0x7f, 0x00, 0x00}, // z { |
+	{0x00, 0x41, 0x36, 0x08, 0x00}, {0x10, 0x08, 0x08, 0x10, 0x08}, // } ~
+}
+
+// Drawn for characters font5x7 has no glyph for, an empty box
+var missingGlyph = [5]byte{0x7f, 0x41, 0x41, 0x41, 0x7f}
+
+// Get the glyph of r
+func glyph(r rune) [5]byte {
+	if r < ' ' || r > '~' {
+		return missingGlyph
+	}
+	return font5x7[r-' ']
+}
+
+// renderRun is a piece of a line drawn in the same style
+type renderRun struct {
+	text string
+	fg   color.RGBA
+	bold bool
+}
+
+// renderBlock is one laid out log entry
+type renderBlock struct {
+	lines [][]renderRun
+	box   bool // Whether the block is a question drawn in a box
+}
+
+// Height of the block in pixels
+func (b renderBlock) height() int {
+	h := len(b.lines) * renderLineH
+	if b.box {
+		h += 2 * renderPad
+	}
+	return h
+}
+
+// Break runs into lines of at most cols characters, between words if possible
+func wrapRuns(runs []renderRun, cols int) (lines [][]renderRun) {
+	var line []renderRun
+	n := 0 // Characters in line
+	put := func(word string, r renderRun) {
+		if len(line) != 0 && line[len(line)-1].fg == r.fg && line[len(line)-1].bold == r.bold {
+			line[len(line)-1].text += word
+		} else {
+			line = append(line, renderRun{word, r.fg, r.bold})
+		}
+		n += len([]rune(word))
+	}
+	newline := func() {
+		lines = append(lines, line)
+		line, n = nil, 0
+	}
+
+	for _, r := range runs {
+		for _, word := range strings.Fields(r.text) {
+			w := []rune(word)
+			if n != 0 && n+1+len(w) > cols {
+				newline()
+			}
+			if n != 0 {
+				put(" ", r)
+			}
+			for k := cols - n; len(w) > k; k = cols {
+				put(string(w[:k]), r)
+				w = w[k:]
+				newline()
+			}
+			put(string(w), r)
+		}
+	}
+	if len(line) != 0 || len(lines) == 0 {
+		newline()
+	}
+	return lines
+}
+
+// Lay out logs in blocks, one for every entry
+func layout(logs []LogEntry) (blocks []renderBlock) {
+	for _, e := range logs {
+		fields := e.Fields()
+		if fields == nil {
+			continue
+		}
+
+		var runs []renderRun
+		cols, box := renderCols, false
+		switch e.Tp {
+		case DEF:
+			runs = []renderRun{{fields[0], renderGray, true}}
+		case Q:
+			runs = []renderRun{{fields[0], renderQText, true}, {fields[1], renderQText, false}}
+			cols, box = renderQCols, true
+		case STR, STR1:
+			runs = []renderRun{{fields[0], renderRed, true}, {fields[1], renderText, false}}
+		case STR2, YOU:
+			runs = []renderRun{{fields[0], renderBlue, true}, {fields[1], renderText, false}}
+		default:
+			runs = []renderRun{{fields[0], renderText, true}, {fields[1], renderText, false}}
+		}
+		blocks = append(blocks, renderBlock{wrapRuns(runs, cols), box})
+	}
+	return blocks
+}
+
+// Height of the image for blocks
+func renderHeight(blocks []renderBlock) int {
+	h := 2 * renderMargin
+	for i, b := range blocks {
+		if i != 0 {
+			h += renderGap
+		}
+		h += b.height()
+	}
+	return h
+}
+
+// Call f with the position of the top left corner of every line
+func eachLine(blocks []renderBlock, f func(b renderBlock, line []renderRun, x, y int)) {
+	y := renderMargin
+	for _, b := range blocks {
+		x, top := renderMargin, y
+		if b.box {
+			x, top = x+renderPad, top+renderPad
+		}
+		for i, line := range b.lines {
+			f(b, line, x, top+i*renderLineH)
+		}
+		y += b.height() + renderGap
+	}
+}
+
+// Draw a glyph with its top left corner at x, y
+func drawGlyph(img *image.RGBA, r rune, x, y int, fg color.RGBA, bold bool) {
+	g := glyph(r)
+	for col, bits := range g {
+		for row := 0; row < 7; row++ {
+			if bits&(1<<uint(row)) == 0 {
+				continue
+			}
+			dot := image.Rect(x+col*renderScale, y+row*renderScale, x+(col+1)*renderScale, y+(row+1)*renderScale)
+			if bold {
+				dot.Max.X++
+			}
+			draw.Draw(img, dot, image.NewUniform(fg), image.Point{}, draw.Src)
+		}
+	}
+}
+
+// RenderImage draws logs in the style of the logs made by Generate: gray DEF
+// entries, questions in a blue box and red or blue labels for who said what.
+// Its font only has the printable ASCII characters, others such as accented
+// letters or Cyrillic are drawn as empty boxes. RenderSVG has no such limit
+func RenderImage(logs []LogEntry) *image.RGBA {
+	blocks := layout(logs)
+	img := image.NewRGBA(image.Rect(0, 0, renderWidth, renderHeight(blocks)))
+	draw.Draw(img, img.Bounds(), image.NewUniform(renderBackground), image.Point{}, draw.Src)
+
+	y := renderMargin
+	for _, b := range blocks {
+		if b.box {
+			box := image.Rect(renderMargin, y, renderWidth-renderMargin, y+b.height())
+			draw.Draw(img, box, image.NewUniform(renderQBorder), image.Point{}, draw.Src)
+			draw.Draw(img, box.Inset(renderScale), image.NewUniform(renderQFill), image.Point{}, draw.Src)
+		}
+		y += b.height() + renderGap
+	}
+
+	eachLine(blocks, func(b renderBlock, line []renderRun, x, y int) {
+		// Center the glyphs in the line
+		y += (renderLineH - renderAscent) / 2
+		for _, run := range line {
+			for _, r := range run.text {
+				drawGlyph(img, r, x, y, run.fg, run.bold)
+				x += renderCellW
+			}
+		}
+	})
+	return img
+}
+
+// RenderPNG writes logs as a PNG image drawn by RenderImage. Unlike Generate
+// it does not need the log server
+func RenderPNG(w io.Writer, logs []LogEntry) error {
+	return png.Encode(w, RenderImage(logs))
+}
+
+// Format c as an SVG colour
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// RenderSVG writes logs as an SVG image laid out like RenderImage, using the
+// viewer's monospace font
+func RenderSVG(w io.Writer, logs []LogEntry) error {
+	blocks := layout(logs)
+	height := renderHeight(blocks)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		renderWidth, height, renderWidth, height)
+	fmt.Fprintf(bw, `<rect width="100%%" height="100%%" fill="%s"/>`+"\n", svgColor(renderBackground))
+
+	y := renderMargin
+	for _, b := range blocks {
+		if b.box {
+			fmt.Fprintf(bw, `<rect x="%d" y="%d" width="%d" height="%d" rx="4" fill="%s" stroke="%s" stroke-width="%d"/>`+"\n",
+				renderMargin, y, renderWidth-2*renderMargin, b.height(), svgColor(renderQFill), svgColor(renderQBorder), renderScale)
+		}
+		y += b.height() + renderGap
+	}
+
+	// A monospace character is about 0.6em wide
+	fontSize := renderCellW * 10 / 6
+	eachLine(blocks, func(b renderBlock, line []renderRun, x, y int) {
+		baseline := y + (renderLineH+renderAscent)/2
+		fmt.Fprintf(bw, `<text x="%d" y="%d" font-family="monospace" font-size="%d" xml:space="preserve">`, x, baseline, fontSize)
+		for _, run := range line {
+			weight := "normal"
+			if run.bold {
+				weight = "bold"
+			}
+			fmt.Fprintf(bw, `<tspan fill="%s" font-weight="%s">%s</tspan>`, svgColor(run.fg), weight, html.EscapeString(run.text))
+		}
+		fmt.Fprint(bw, "</text>\n")
+	})
+	fmt.Fprint(bw, "</svg>\n")
+	return bw.Flush()
+}
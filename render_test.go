@@ -0,0 +1,95 @@
+package gomegle
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestRenderPNG(t *testing.T) {
+	logs := exampleTranscript().LogEntries()
+	var buf bytes.Buffer
+	if err := RenderPNG(&buf, logs); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := img.Bounds().Dx(); w != renderWidth {
+		t.Errorf("got width %d, want %d", w, renderWidth)
+	}
+
+	seen := map[[3]uint32]bool{}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			seen[[3]uint32{r >> 8, g >> 8, b >> 8}] = true
+		}
+	}
+	for name, c := range map[string][3]uint32{
+		"DEF text":     {0x55, 0x55, 0x55},
+		"question box": {0xe8, 0xf0, 0xfd},
+		"red label":    {0xff, 0x00, 0x00},
+		"blue label":   {0x00, 0x00, 0xff},
+		"message text": {0x00, 0x00, 0x00},
+	} {
+		if !seen[c] {
+			t.Errorf("no %s in the image", name)
+		}
+	}
+}
+
+func TestRenderSVG(t *testing.T) {
+	logs := exampleTranscript().LogEntries()
+	var buf bytes.Buffer
+	if err := RenderSVG(&buf, logs); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<svg ", `fill="#ff0000" font-weight="bold">Stranger:</tspan>`,
+		"&lt;b&gt;hello&lt;/b&gt;", `fill="#e8f0fd"`, "</svg>\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("SVG is missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestWrapRuns(t *testing.T) {
+	runs := []renderRun{{"You:", renderBlue, true}, {"abc defgh " + strings.Repeat("x", 12), renderText, false}}
+	lines := wrapRuns(runs, 10)
+	var got []string
+	for _, line := range lines {
+		s := ""
+		for _, r := range line {
+			s += r.text
+		}
+		got = append(got, s)
+	}
+	want := []string{"You: abc", "defgh", "xxxxxxxxxx", "xx"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRenderNonASCII(t *testing.T) {
+	render := func(text string) []byte {
+		return RenderImage([]LogEntry{{STR, text, ""}}).Pix
+	}
+	box := render("ж")
+	if bytes.Equal(box, render("?")) || bytes.Equal(box, render(" ")) {
+		t.Error("a character without a glyph is not drawn as a box")
+	}
+	if !bytes.Equal(box, render("é")) {
+		t.Error("characters without a glyph are drawn differently")
+	}
+
+	var buf bytes.Buffer
+	if err := RenderSVG(&buf, []LogEntry{{STR, "привет, café", ""}}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "привет, café") {
+		t.Errorf("SVG lost the text:\n%s", buf.String())
+	}
+}
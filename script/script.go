@@ -0,0 +1,272 @@
+// Package script runs scripted conversations. A script is a small state
+// machine read from a JSON file: every state sends some messages and then
+// moves on to another state when the stranger says something matching a
+// regexp or when nobody said anything for a while. For example
+//
+//	{
+//		"start": "greet",
+//		"states": {
+//			"greet": {
+//				"send": ["hi", "what's your name?"],
+//				"timeout": "1m",
+//				"on_timeout": "bye",
+//				"transitions": [
+//					{"match": "(?i)^(?:i'm|my name is) (\\w+)", "goto": "named"},
+//					{"match": "(?i)\\b(?:m|f)\\b", "goto": "bye"}
+//				]
+//			},
+//			"named": {"send": ["nice to meet you $1"], "timeout": "30s", "on_timeout": "bye"},
+//			"bye": {"send": ["bye"]}
+//		}
+//	}
+package script
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/GiedriusS/gomegle/bot"
+)
+
+// Script is a scripted conversation
+type Script struct {
+	Start  string            `json:"start"`  // The state conversations start in
+	States map[string]*State `json:"states"` // By name
+}
+
+// State is a step of a script. A state without transitions and timeout ends
+// the conversation once its messages are sent
+type State struct {
+	// Optional, messages sent in order when the state is entered. They can
+	// refer to the groups of the regexp that led to the state as in
+	// regexp.Expand, such as $1 or ${name}
+	Send []string `json:"send"`
+	// Optional, if not 0 then the state is left for OnTimeout when no
+	// transition is taken for this long after entering it
+	Timeout Duration `json:"timeout"`
+	// Optional, the state entered after Timeout. If empty the conversation
+	// ends instead
+	OnTimeout string `json:"on_timeout"`
+	// Optional, checked in order against every message of the stranger, the
+	// first that matches is taken. Messages that match none are ignored
+	Transitions []*Transition `json:"transitions"`
+}
+
+// Transition moves to another state when the stranger says something
+type Transition struct {
+	Match string `json:"match"` // Regexp the message has to match, see regexp/syntax
+	Goto  string `json:"goto"`  // The state to enter
+
+	re *regexp.Regexp
+}
+
+// Duration is a time.Duration written in JSON as a string such as "1m30s"
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration with time.ParseDuration
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string such as "1m30s"
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Parse reads a script and checks that it can be run
+func Parse(r io.Reader) (*Script, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var sc Script
+	if err := dec.Decode(&sc); err != nil {
+		return nil, err
+	}
+	if err := sc.compile(); err != nil {
+		return nil, err
+	}
+	return &sc, nil
+}
+
+// Load reads a script from a file with Parse
+func Load(path string) (*Script, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return sc, nil
+}
+
+// Compile the regexps and check that every state referred to exists
+func (sc *Script) compile() error {
+	if sc.Start == "" {
+		return errors.New("no start state")
+	}
+	if sc.States[sc.Start] == nil {
+		return fmt.Errorf("unknown start state %q", sc.Start)
+	}
+	for name, st := range sc.States {
+		if st == nil {
+			return fmt.Errorf("state %q: empty", name)
+		}
+		if st.Timeout < 0 {
+			return fmt.Errorf("state %q: negative timeout", name)
+		}
+		if st.OnTimeout != "" && sc.States[st.OnTimeout] == nil {
+			return fmt.Errorf("state %q: unknown state %q", name, st.OnTimeout)
+		}
+		if st.OnTimeout != "" && st.Timeout == 0 {
+			return fmt.Errorf("state %q: on_timeout without a timeout", name)
+		}
+		for _, t := range st.Transitions {
+			if t == nil || sc.States[t.Goto] == nil {
+				return fmt.Errorf("state %q: transition to an unknown state", name)
+			}
+			re, err := regexp.Compile(t.Match)
+			if err != nil {
+				return fmt.Errorf("state %q: %w", name, err)
+			}
+			t.re = re
+		}
+	}
+	return nil
+}
+
+// Runner runs a script in every conversation of a bot
+type Runner struct {
+	Script  *Script                                 // Read with Parse or Load
+	OnEnter func(c *bot.Conversation, state string) // Optional, called whenever a state is entered
+	OnError func(c *bot.Conversation, err error)    // Optional, called when sending a message fails
+}
+
+// run is a script running in one conversation
+type run struct {
+	r *Runner
+	c *bot.Conversation
+
+	m     sync.Mutex
+	state *State
+	gen   int // Changes with every state so that stale timeouts are ignored
+	timer *time.Timer
+	done  bool // The conversation ended
+}
+
+// Handle adds the handlers that run the script to a router, usually that of
+// a bot.Bot. The script starts when a stranger connects
+func (r *Runner) Handle(router *bot.Router) {
+	router.OnConnect(func(c *bot.Conversation) {
+		ru := &run{r: r, c: c}
+		c.Set("script", ru)
+		defer ru.m.Unlock()
+		ru.m.Lock()
+		ru.enter(r.Script.Start, nil)
+	})
+	router.OnMessage(func(c *bot.Conversation, text string) {
+		if ru, ok := c.Get("script").(*run); ok {
+			ru.message(text)
+		}
+	})
+	router.OnDisconnect(func(c *bot.Conversation) {
+		if ru, ok := c.Get("script").(*run); ok {
+			ru.stop()
+		}
+	})
+}
+
+// Enter a state, expanding the messages with expand if not nil. Must be
+// called with m held
+func (ru *run) enter(name string, expand func(string) string) {
+	st := ru.r.Script.States[name]
+	ru.state = st
+	ru.gen++
+	if ru.timer != nil {
+		ru.timer.Stop()
+		ru.timer = nil
+	}
+	if ru.r.OnEnter != nil {
+		ru.r.OnEnter(ru.c, name)
+	}
+
+	for _, msg := range st.Send {
+		if expand != nil {
+			msg = expand(msg)
+		}
+		if err := ru.c.Send(msg); err != nil {
+			if ru.r.OnError != nil {
+				ru.r.OnError(ru.c, err)
+			}
+			break
+		}
+	}
+
+	switch {
+	case st.Timeout != 0:
+		gen := ru.gen
+		ru.timer = time.AfterFunc(time.Duration(st.Timeout), func() { ru.timeout(gen) })
+	case len(st.Transitions) == 0:
+		ru.done = true
+		ru.c.Leave()
+	}
+}
+
+// Take the first transition of the current state matching a message
+func (ru *run) message(text string) {
+	defer ru.m.Unlock()
+	ru.m.Lock()
+	if ru.done {
+		return
+	}
+	for _, t := range ru.state.Transitions {
+		m := t.re.FindStringSubmatchIndex(text)
+		if m == nil {
+			continue
+		}
+		re := t.re
+		ru.enter(t.Goto, func(s string) string { return string(re.ExpandString(nil, s, text, m)) })
+		return
+	}
+}
+
+// Leave the state entered as generation gen after its timeout
+func (ru *run) timeout(gen int) {
+	defer ru.m.Unlock()
+	ru.m.Lock()
+	if ru.done || ru.gen != gen {
+		return
+	}
+	if ru.state.OnTimeout == "" {
+		ru.done = true
+		ru.c.Leave()
+		return
+	}
+	ru.enter(ru.state.OnTimeout, nil)
+}
+
+// Stop the script when the conversation ends
+func (ru *run) stop() {
+	defer ru.m.Unlock()
+	ru.m.Lock()
+	ru.done = true
+	if ru.timer != nil {
+		ru.timer.Stop()
+	}
+}
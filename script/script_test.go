@@ -0,0 +1,106 @@
+package script
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GiedriusS/gomegle"
+	"github.com/GiedriusS/gomegle/bot"
+	"github.com/GiedriusS/gomegle/gomegletest"
+)
+
+const testScript = `{
+	"start": "greet",
+	"states": {
+		"greet": {
+			"send": ["hi", "what's your name?"],
+			"timeout": "5s",
+			"on_timeout": "bye",
+			"transitions": [
+				{"match": "(?i)^my name is (?P<name>\\w+)", "goto": "named"},
+				{"match": "^bye$", "goto": "bye"}
+			]
+		},
+		"named": {"send": ["nice to meet you ${name}"], "timeout": "50ms", "on_timeout": "bye"},
+		"bye": {"send": ["bye"]}
+	}
+}`
+
+func TestParse(t *testing.T) {
+	sc, err := Parse(strings.NewReader(testScript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Start != "greet" || len(sc.States) != 3 || time.Duration(sc.States["named"].Timeout) != 50*time.Millisecond {
+		t.Errorf("wrong script: %+v", sc)
+	}
+
+	for _, bad := range []string{
+		`{"states": {"a": {}}}`,
+		`{"start": "b", "states": {"a": {}}}`,
+		`{"start": "a", "states": {"a": {"timeout": "1s", "on_timeout": "b"}}}`,
+		`{"start": "a", "states": {"a": {"on_timeout": "a"}}}`,
+		`{"start": "a", "states": {"a": {"timeout": "soon"}}}`,
+		`{"start": "a", "states": {"a": {"transitions": [{"match": "(", "goto": "a"}]}}}`,
+		`{"start": "a", "states": {"a": {"transitions": [{"match": "x", "goto": "b"}]}}}`,
+		`{"start": "a", "states": {"a": {"sned": ["typo"]}}}`,
+	} {
+		if _, err := Parse(strings.NewReader(bad)); err == nil {
+			t.Errorf("no error parsing %s", bad)
+		}
+	}
+}
+
+func TestRunner(t *testing.T) {
+	srv := gomegletest.NewServer()
+	defer srv.Close()
+
+	sc, err := Parse(strings.NewReader(testScript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m sync.Mutex
+	var states []string
+	r := &Runner{Script: sc, OnEnter: func(c *bot.Conversation, state string) {
+		defer m.Unlock()
+		m.Lock()
+		states = append(states, state)
+	}}
+	b := &bot.Bot{Omegle: &gomegle.Omegle{Client: &http.Client{Transport: srv.Transport()}}}
+	r.Handle(&b.Router)
+
+	done := make(chan error)
+	go func() { done <- b.Run(context.Background()) }()
+
+	chat := srv.WaitChat(0, time.Second)
+	if chat == nil {
+		t.Fatal("did not start a conversation")
+	}
+	chat.WaitMessages(2, time.Second)
+	chat.Send("what?")
+	chat.Send("My name is Ann")
+	want := []string{"hi", "what's your name?", "nice to meet you Ann", "bye"}
+	if got := chat.WaitMessages(4, time.Second); !reflect.DeepEqual(got, want) {
+		t.Errorf("got messages %v, want %v", got, want)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the script did not end the conversation")
+	}
+	if !chat.Ended() {
+		t.Error("did not disconnect")
+	}
+	if want := []string{"greet", "named", "bye"}; !reflect.DeepEqual(states, want) {
+		t.Errorf("got states %v, want %v", states, want)
+	}
+}
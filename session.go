@@ -0,0 +1,474 @@
+package gomegle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// How the poller of a Session retries failed requests to /events
+const (
+	pollRetries    = 5                      // Give up after this many failures in a row
+	pollBackoff    = 250 * time.Millisecond // Wait this long after the first failure
+	pollMaxBackoff = 8 * time.Second        // Never wait longer than this
+)
+
+// How long Close waits for the server to confirm the disconnect
+const closeTimeout = 10 * time.Second
+
+// Session is a single conversation whose events are polled in the background.
+// All of its methods are safe to call from multiple goroutines
+type Session struct {
+	conf   *Omegle // Copy of the configuration the session was started with
+	randid string
+	events chan EventData
+	// Returned by Events instead of events if not nil, set by replay before
+	// the session is handed out
+	replayed chan EventData
+	ctx      context.Context // Cancelled by Close to stop the poller
+	cancel   context.CancelFunc
+	done     chan struct{} // Closed when the poller returns
+	// Everything that happened, nil if the session was started without Record
+	transcript *Transcript
+
+	m        sync.Mutex
+	chat     chat
+	err      error         // Why the poller gave up, if it did
+	ended    bool          // Whether the conversation ended on the server side
+	endedc   chan struct{} // Closed when ended is set
+	closeErr error         // Returned by Close
+
+	closeOnce sync.Once // Disconnects in Close without holding m
+}
+
+// Start starts a new conversation and polls its events in the background
+// until the conversation ends or Close is called. ctx is only used for
+// starting the conversation. The session keeps the configuration it was
+// started with so o can be changed afterwards without affecting it
+func (o *Omegle) Start(ctx context.Context) (*Session, error) {
+	s := &Session{
+		conf:   o.Config(),
+		randid: newRandID(),
+		events: make(chan EventData, 16),
+		done:   make(chan struct{}),
+		endedc: make(chan struct{}),
+	}
+
+	c, err := s.conf.start(ctx, s.randid)
+	if err != nil {
+		return nil, err
+	}
+	s.chat = c
+	if s.conf.Record {
+		s.transcript = &Transcript{}
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.poll()
+	return s, nil
+}
+
+// ID returns the id omegle gave to the conversation
+func (s *Session) ID() string {
+	return s.current().id
+}
+
+// Server returns the front server the conversation was started on, "" for
+// the main one
+func (s *Session) Server() string {
+	return s.current().server
+}
+
+// Get the current conversation
+func (s *Session) current() chat {
+	defer s.m.Unlock()
+	s.m.Lock()
+	return s.chat
+}
+
+// Transcript returns what happened in the session so far, including what we
+// did. It is nil unless the session was started with Record set
+func (s *Session) Transcript() *Transcript {
+	return s.transcript
+}
+
+// Add a record to the transcript, if there is one
+func (s *Session) record(ev EventData, sent bool) {
+	if s.transcript != nil {
+		s.transcript.add(ev, sent)
+	}
+}
+
+// Events returns the channel on which the events of the conversation are
+// delivered in order. It is closed once the conversation ends (after
+// DISCONNECTED, CONNECTIONDIED, ERROR or SPYDISCONNECTED is delivered), the
+// poller gives up or Close is called. If the Omegle the session was started
+// from has a ReconnectPolicy, new conversations are started instead of
+// closing the channel for as long as the policy allows and their events
+// follow on the same channel
+func (s *Session) Events() <-chan EventData {
+	if s.replayed != nil {
+		return s.replayed
+	}
+	return s.events
+}
+
+// Deliver events that were already read from Events again, before the rest
+func (s *Session) replay(seen []EventData) {
+	events := s.Events()
+	replayed := make(chan EventData, cap(s.events))
+	s.replayed = replayed
+	go func() {
+		defer close(replayed)
+		for _, ev := range seen {
+			select {
+			case replayed <- ev:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+		for ev := range events {
+			select {
+			case replayed <- ev:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Err returns the error the session ended with, such as the last network
+// error or the text of an ERROR event. It is nil while the session is
+// running and if the stranger left or Close was called
+func (s *Session) Err() error {
+	defer s.m.Unlock()
+	s.m.Lock()
+	return s.err
+}
+
+// Close stops the poller and disconnects from the conversation if it has
+// not ended yet, giving up if the server does not answer within a few
+// seconds. It is safe to call Close more than once, later calls wait for the
+// first to finish and return its error
+func (s *Session) Close() error {
+	s.cancel()
+	<-s.done
+
+	s.closeOnce.Do(func() {
+		s.m.Lock()
+		c, ended := s.chat, s.ended
+		s.m.Unlock()
+
+		var err error
+		if !ended {
+			ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+			defer cancel()
+			if err = s.conf.disconnect(ctx, c); err == nil {
+				s.record(DISCONNECTED, true)
+			}
+		}
+
+		defer s.m.Unlock()
+		s.m.Lock()
+		s.closeErr = err
+	})
+
+	defer s.m.Unlock()
+	s.m.Lock()
+	return s.closeErr
+}
+
+// SendMessage sends a message to the stranger
+func (s *Session) SendMessage(msg string) error {
+	return s.SendMessageContext(context.Background(), msg)
+}
+
+// SendMessageContext is like SendMessage but aborts when ctx is done
+func (s *Session) SendMessageContext(ctx context.Context, msg string) error {
+	err := s.conf.sendMessage(ctx, s.current(), msg)
+	if err == nil {
+		s.record(MessageEvent{msg}, true)
+	}
+	return err
+}
+
+// ShowTyping shows to the stranger that we are typing
+func (s *Session) ShowTyping() error {
+	return s.ShowTypingContext(context.Background())
+}
+
+// ShowTypingContext is like ShowTyping but aborts when ctx is done
+func (s *Session) ShowTypingContext(ctx context.Context) error {
+	err := s.conf.showTyping(ctx, s.current())
+	if err == nil {
+		s.record(TYPING, true)
+	}
+	return err
+}
+
+// StopTyping shows to the stranger that we stopped typing
+func (s *Session) StopTyping() error {
+	return s.StopTypingContext(context.Background())
+}
+
+// StopTypingContext is like StopTyping but aborts when ctx is done
+func (s *Session) StopTypingContext(ctx context.Context) error {
+	err := s.conf.stopTyping(ctx, s.current())
+	if err == nil {
+		s.record(STOPPEDTYPING, true)
+	}
+	return err
+}
+
+// StopLookingForCommonLikes stops looking for strangers only interested in
+// the topics the session was started with
+func (s *Session) StopLookingForCommonLikes() error {
+	return s.StopLookingForCommonLikesContext(context.Background())
+}
+
+// StopLookingForCommonLikesContext is like StopLookingForCommonLikes but aborts when ctx is done
+func (s *Session) StopLookingForCommonLikesContext(ctx context.Context) error {
+	return s.conf.stopLookingForCommonLikes(ctx, s.current())
+}
+
+// Recaptcha sends back the response to given challenge to omegle
+func (s *Session) Recaptcha(challenge, response string) error {
+	return s.RecaptchaContext(context.Background(), challenge, response)
+}
+
+// RecaptchaContext is like Recaptcha but aborts when ctx is done
+func (s *Session) RecaptchaContext(ctx context.Context, challenge, response string) error {
+	return s.conf.recaptcha(ctx, s.current(), challenge, response)
+}
+
+// Generate sends a request to generate a log file of the conversation to
+// omegle and returns the image link
+func (s *Session) Generate(identdigests string, logs []LogEntry) (url string, err error) {
+	return s.GenerateContext(context.Background(), identdigests, logs)
+}
+
+// GenerateContext is like Generate but aborts when ctx is done
+func (s *Session) GenerateContext(ctx context.Context, identdigests string, logs []LogEntry) (url string, err error) {
+	return s.conf.generate(ctx, s.current(), s.randid, identdigests, logs)
+}
+
+// Wait for d or until the session is closed. Returns false in the latter case
+func (s *Session) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// Record why the poller gave up
+func (s *Session) setErr(err error) {
+	defer s.m.Unlock()
+	s.m.Lock()
+	s.err = err
+}
+
+// Mark whether the current conversation ended on the server side
+func (s *Session) setEnded(ended bool) {
+	defer s.m.Unlock()
+	s.m.Lock()
+	if ended && !s.ended {
+		close(s.endedc)
+	}
+	s.ended = ended
+}
+
+// Get a channel that is closed when the current conversation ends on the
+// server side
+func (s *Session) conversationEnded() <-chan struct{} {
+	defer s.m.Unlock()
+	s.m.Lock()
+	return s.endedc
+}
+
+// poll runs the /events long-poll loop and reconnects as allowed by the
+// reconnect policy
+func (s *Session) poll() {
+	defer close(s.done)
+	defer close(s.events)
+
+	policy := s.conf.Reconnect
+	attempt := 0
+	for {
+		reason, ok, err := s.pollConversation(&attempt)
+		if !ok {
+			return
+		}
+
+		for {
+			if !policy.allows(reason, attempt) {
+				s.setErr(err)
+				return
+			}
+			attempt++
+			delay := policy.Backoff(attempt)
+			if policy.OnRetry != nil {
+				policy.OnRetry(Retry{attempt, reason, err, delay})
+			}
+			if !s.sleep(delay) {
+				return
+			}
+
+			c, startErr := s.conf.start(s.ctx, s.randid)
+			if s.ctx.Err() != nil {
+				return
+			}
+			if startErr == nil {
+				s.m.Lock()
+				s.chat = c
+				s.ended = false
+				s.endedc = make(chan struct{})
+				s.m.Unlock()
+				if s.transcript != nil {
+					s.transcript.newConversation()
+				}
+				break
+			}
+			reason, err = NetworkFailure, startErr
+		}
+	}
+}
+
+// pollConversation delivers the events of the current conversation until it
+// ends. It returns why it ended and false if the session was closed instead.
+// attempt is reset once a stranger connects
+func (s *Session) pollConversation(attempt *int) (reason Reason, ok bool, err error) {
+	failures := 0
+	backoff := pollBackoff
+
+	// Until when to wait for a stranger with topics, zero if forever
+	var deadline time.Time
+	topics := s.conf.Topics
+	fallback := s.conf.TopicFallback
+	if fallback != nil && fallback.Timeout > 0 && len(topics) != 0 {
+		deadline = time.Now().Add(fallback.Timeout)
+	}
+
+	for {
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			topics, err = s.fallBack(topics)
+			if s.ctx.Err() != nil {
+				return 0, false, nil
+			}
+			if err != nil {
+				s.setEnded(true)
+				return NetworkFailure, true, err
+			}
+			deadline = time.Time{}
+			if len(topics) != 0 {
+				deadline = time.Now().Add(fallback.Timeout)
+			}
+		}
+
+		// Stop polling when the deadline passes to take the next step
+		ctx, cancel := s.ctx, context.CancelFunc(func() {})
+		if !deadline.IsZero() {
+			ctx, cancel = context.WithDeadline(s.ctx, deadline)
+		}
+		c := s.current()
+		events, err := s.conf.pollEvents(ctx, c)
+		cancel()
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return 0, false, nil
+			}
+			if ctx.Err() != nil {
+				continue
+			}
+			failures++
+			if failures >= pollRetries {
+				if s.conf.Pool != nil {
+					s.conf.Pool.failed(c.server)
+				}
+				return NetworkFailure, true, err
+			}
+			if !s.sleep(backoff) {
+				return 0, false, nil
+			}
+			if backoff *= 2; backoff > pollMaxBackoff {
+				backoff = pollMaxBackoff
+			}
+			continue
+		}
+		failures = 0
+		backoff = pollBackoff
+
+		for _, ev := range events {
+			s.record(ev, false)
+			select {
+			case s.events <- ev:
+			case <-s.ctx.Done():
+				return 0, false, nil
+			}
+
+			switch ev := ev.(type) {
+			case ErrorEvent:
+				s.setEnded(true)
+				return ServerError, true, &Error{"Session", ErrServerError, ev.Text, 0}
+			case SpyDisconnectedEvent:
+				s.setEnded(true)
+				return StrangerLeft, true, nil
+			case CommonLikesEvent:
+				if fallback != nil {
+					fallback.liked(ev.Topics)
+				}
+			case Event:
+				switch ev {
+				case CONNECTED:
+					*attempt = 0
+					deadline = time.Time{}
+				case DISCONNECTED:
+					s.setEnded(true)
+					return StrangerLeft, true, nil
+				case CONNECTIONDIED:
+					s.setEnded(true)
+					return NetworkFailure, true, nil
+				}
+			}
+		}
+	}
+}
+
+// Take the next step of the topic fallback after no stranger connected in
+// time and return the topics searched for from then on. The search is
+// started again with fewer topics or omegle is told to look for any
+// stranger. An error means the current conversation was left
+func (s *Session) fallBack(topics []string) ([]string, error) {
+	fallback := s.conf.TopicFallback
+	if !fallback.Broaden || len(topics) < 2 {
+		// Failing to stop looking is not fatal, the stranger might have just
+		// connected and otherwise the next step tries again
+		if s.conf.stopLookingForCommonLikes(s.ctx, s.current()) != nil {
+			return topics, nil
+		}
+		topics = nil
+	} else {
+		topics = fallback.drop(topics)
+		conf := s.conf.Config()
+		conf.Topics = topics
+		s.conf.disconnect(s.ctx, s.current())
+		c, err := conf.start(s.ctx, s.randid)
+		if err != nil {
+			return nil, err
+		}
+		s.m.Lock()
+		s.chat = c
+		s.m.Unlock()
+		if s.transcript != nil {
+			s.transcript.newConversation()
+		}
+	}
+
+	if fallback.OnFallback != nil {
+		fallback.OnFallback(topics)
+	}
+	return topics, nil
+}
@@ -0,0 +1,211 @@
+package gomegle
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Read events from s until the channel is closed or it takes too long
+func collect(t *testing.T, s *Session) (events []EventData) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-s.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, ev)
+		case <-timeout:
+			t.Fatal("events channel was not closed")
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	var o Omegle
+	s, err := o.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat := srv.Lookup(s.ID())
+	if chat == nil {
+		t.Fatal("chat was not started on the server")
+	}
+
+	err = s.SendMessage("hi")
+	if err != nil {
+		t.Error(err)
+	}
+	chat.Typing()
+	chat.Send("hello")
+	chat.Disconnect()
+
+	events := collect(t, s)
+	want := []Event{WAITING, CONNECTED, IDENTDIGESTS, TYPING, MESSAGE, DISCONNECTED}
+	if len(events) != len(want) {
+		t.Fatalf("got %v events, want %v", events, want)
+	}
+	for i := range want {
+		if events[i].Type() != want[i] {
+			t.Errorf("event %d is %v, want %v", i, events[i].Type(), want[i])
+		}
+	}
+
+	err = s.Close()
+	if err != nil {
+		t.Error(err)
+	}
+	if chat.Ended() {
+		t.Error("Close disconnected from a conversation that already ended")
+	}
+	if s.Err() != nil {
+		t.Error(s.Err())
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	var o Omegle
+	s, err := o.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat := srv.Lookup(s.ID())
+
+	err = s.Close()
+	if err != nil {
+		t.Error(err)
+	}
+	collect(t, s)
+	if !chat.Ended() {
+		t.Error("Close did not disconnect")
+	}
+	if err = s.Close(); err != nil {
+		t.Error("second Close failed: ", err)
+	}
+}
+
+// stallTransport holds requests to /disconnect until release is closed
+type stallTransport struct {
+	base    http.RoundTripper
+	release chan struct{}
+}
+
+func (st *stallTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Path, "/disconnect") {
+		select {
+		case <-st.release:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	return st.base.RoundTrip(req)
+}
+
+func TestSessionCloseStalled(t *testing.T) {
+	st := &stallTransport{srv.Transport(), make(chan struct{})}
+	o := Omegle{Client: &http.Client{Transport: st}}
+	s, err := o.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan error, 2)
+	go func() { closed <- s.Close() }()
+	time.Sleep(50 * time.Millisecond)
+	got := make(chan struct{})
+	go func() {
+		s.Err()
+		s.ID()
+		close(got)
+	}()
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatal("a stalled Close blocked the other methods")
+	}
+
+	go func() { closed <- s.Close() }()
+	close(st.release)
+	for i := 0; i < 2; i++ {
+		if err := <-closed; err != nil {
+			t.Error(err)
+		}
+	}
+	if !srv.Lookup(s.ID()).Ended() {
+		t.Error("Close did not disconnect")
+	}
+}
+
+func TestSessionStartError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var o Omegle
+	_, err := o.Start(ctx)
+	if err != context.Canceled {
+		t.Error("expected context.Canceled, got ", err)
+	}
+}
+
+func TestSessionsConcurrent(t *testing.T) {
+	o := Omegle{Topics: []string{"go"}}
+
+	var wg sync.WaitGroup
+	sessions := make([]*Session, 8)
+	for i := range sessions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, err := o.Start(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			sessions[i] = s
+			if err := s.SendMessage(fmt.Sprint(i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Changing the configuration must not affect running sessions
+	o.Topics[0] = "changed"
+	o.Topics = nil
+
+	ids := map[string]bool{}
+	for i, s := range sessions {
+		if s == nil {
+			t.Fatal("session was not started")
+		}
+		if ids[s.ID()] {
+			t.Error("two sessions got the same id")
+		}
+		ids[s.ID()] = true
+
+		chat := srv.Lookup(s.ID())
+		if msgs := chat.Messages(); len(msgs) != 1 || msgs[0] != fmt.Sprint(i) {
+			t.Error("message went to the wrong conversation: ", msgs)
+		}
+		if err := s.StopLookingForCommonLikes(); err != nil {
+			t.Error(err)
+		}
+		chat.Disconnect()
+	}
+
+	for _, s := range sessions {
+		go s.ShowTyping()
+		events := collect(t, s)
+		if len(events) == 0 || events[len(events)-1] != DISCONNECTED {
+			t.Error("did not get disconnected: ", events)
+		}
+		if err := s.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
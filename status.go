@@ -0,0 +1,219 @@
+package gomegle
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Default values used when the fields of StatusWatcher are zero
+const (
+	DefaultStatusInterval = time.Minute
+	DefaultStatusHistory  = 24 * 60
+)
+
+// StatusSample is one point of the status time series kept by StatusWatcher
+type StatusSample struct {
+	Time            time.Time // From Status.Timestamp, or when the status was fetched if it has none
+	Count           int
+	SpyQueueTime    float64
+	SpyeeQueueTime  float64
+	Antinudepercent float64
+}
+
+// StatusWatcher polls /status and keeps the latest samples of the values
+// that change over time. A status is only kept if its Timestamp is newer
+// than that of the last one, so the same status is never kept twice. The
+// zero value is ready to use
+type StatusWatcher struct {
+	Omegle   *Omegle       // Optional, where the status is fetched from, the default configuration if nil
+	Interval time.Duration // Optional, how often to poll, DefaultStatusInterval if 0
+	Size     int           // Optional, how many samples are kept, DefaultStatusHistory if 0 or less
+	OnError  func(error)   // Optional, called with every failed poll in Run
+
+	m       sync.Mutex
+	samples []StatusSample // Ring buffer
+	next    int            // Where the next sample goes in samples
+	last    float64        // Timestamp of the newest sample
+	subs    map[chan StatusSample]struct{}
+}
+
+// Run polls until ctx is done and returns its error. Failed polls are passed
+// to OnError and retried at the next interval
+func (w *StatusWatcher) Run(ctx context.Context) error {
+	interval := w.Interval
+	if interval == 0 {
+		interval = DefaultStatusInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := w.Poll(ctx); err != nil && ctx.Err() == nil && w.OnError != nil {
+			w.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll fetches the status once and adds it. It reports whether the status
+// was new
+func (w *StatusWatcher) Poll(ctx context.Context) (bool, error) {
+	o := w.Omegle
+	if o == nil {
+		o = &Omegle{}
+	}
+	st, err := o.GetStatusContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	return w.Add(st), nil
+}
+
+// Add adds a status obtained some other way, such as from a
+// StatusInfoEvent, and notifies the subscribers. It reports whether the
+// status was new. A status without a Timestamp is always new
+func (w *StatusWatcher) Add(st Status) bool {
+	defer w.m.Unlock()
+	w.m.Lock()
+
+	sample := StatusSample{time.Now(), st.Count, st.SpyQueueTime, st.SpyeeQueueTime, st.Antinudepercent}
+	if st.Timestamp != 0 {
+		if st.Timestamp <= w.last {
+			return false
+		}
+		w.last = st.Timestamp
+		sec, frac := math.Modf(st.Timestamp)
+		sample.Time = time.Unix(int64(sec), int64(frac*1e9))
+	}
+
+	size := w.size()
+	if len(w.samples) < size {
+		w.samples = append(w.samples, sample)
+	} else {
+		w.samples[w.next] = sample
+	}
+	w.next = (w.next + 1) % size
+
+	for ch := range w.subs {
+		select {
+		case ch <- sample:
+		default:
+		}
+	}
+	return true
+}
+
+// How many samples are kept
+func (w *StatusWatcher) size() int {
+	if w.Size <= 0 {
+		return DefaultStatusHistory
+	}
+	return w.Size
+}
+
+// Samples returns the samples kept, oldest first
+func (w *StatusWatcher) Samples() []StatusSample {
+	defer w.m.Unlock()
+	w.m.Lock()
+	if len(w.samples) < w.size() {
+		return append([]StatusSample(nil), w.samples...)
+	}
+	return append(append([]StatusSample(nil), w.samples[w.next:]...), w.samples[:w.next]...)
+}
+
+// Subscribe returns a channel on which every new sample is sent and a
+// function that stops sending them. Samples are dropped if the subscriber
+// falls more than a few behind
+func (w *StatusWatcher) Subscribe() (<-chan StatusSample, func()) {
+	defer w.m.Unlock()
+	w.m.Lock()
+	if w.subs == nil {
+		w.subs = make(map[chan StatusSample]struct{})
+	}
+	ch := make(chan StatusSample, 16)
+	w.subs[ch] = struct{}{}
+	return ch, func() {
+		defer w.m.Unlock()
+		w.m.Lock()
+		delete(w.subs, ch)
+	}
+}
+
+// WriteCSV writes the samples kept as CSV, see WriteStatusCSV
+func (w *StatusWatcher) WriteCSV(out io.Writer) error {
+	return WriteStatusCSV(out, w.Samples(), true)
+}
+
+// Columns of the CSV written by WriteStatusCSV
+var statusCSVHeader = []string{"time", "count", "spy_queue_time", "spyee_queue_time", "antinude_percent"}
+
+// WriteStatusCSV writes samples as CSV, one per row, with the time in
+// RFC 3339 format. The header is only written if header is true, so that
+// new samples can be appended to an existing file
+func WriteStatusCSV(w io.Writer, samples []StatusSample, header bool) error {
+	out := csv.NewWriter(w)
+	if header {
+		out.Write(statusCSVHeader)
+	}
+	for _, s := range samples {
+		out.Write([]string{
+			s.Time.Format(time.RFC3339Nano),
+			strconv.Itoa(s.Count),
+			strconv.FormatFloat(s.SpyQueueTime, 'g', -1, 64),
+			strconv.FormatFloat(s.SpyeeQueueTime, 'g', -1, 64),
+			strconv.FormatFloat(s.Antinudepercent, 'g', -1, 64),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// ReadStatusCSV reads samples written by WriteStatusCSV, with or without the
+// header
+func ReadStatusCSV(r io.Reader) (samples []StatusSample, err error) {
+	in := csv.NewReader(r)
+	in.FieldsPerRecord = len(statusCSVHeader)
+	records, err := in.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) != 0 && records[0][0] == statusCSVHeader[0] {
+		records = records[1:]
+	}
+
+	for i, rec := range records {
+		s, err := parseStatusRecord(rec)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
+
+// Parse a row written by WriteStatusCSV
+func parseStatusRecord(rec []string) (s StatusSample, err error) {
+	if s.Time, err = time.Parse(time.RFC3339Nano, rec[0]); err != nil {
+		return s, err
+	}
+	if s.Count, err = strconv.Atoi(rec[1]); err != nil {
+		return s, err
+	}
+	if s.SpyQueueTime, err = strconv.ParseFloat(rec[2], 64); err != nil {
+		return s, err
+	}
+	if s.SpyeeQueueTime, err = strconv.ParseFloat(rec[3], 64); err != nil {
+		return s, err
+	}
+	s.Antinudepercent, err = strconv.ParseFloat(rec[4], 64)
+	return s, err
+}
@@ -0,0 +1,135 @@
+package gomegle
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GiedriusS/gomegle/gomegletest"
+)
+
+// A status the fake server can serve
+func fakeStatus(count int, spyQueueTime, timestamp float64) gomegletest.Status {
+	return gomegletest.Status{
+		Count:           count,
+		Antinudeservers: []string{"waw1"},
+		SpyQueueTime:    spyQueueTime,
+		Timestamp:       timestamp,
+		Servers:         []string{"front1"},
+	}
+}
+
+func TestStatusWatcherPoll(t *testing.T) {
+	fake := gomegletest.NewServer()
+	defer fake.Close()
+	w := &StatusWatcher{Omegle: &Omegle{Client: &http.Client{Transport: fake.Transport()}}}
+
+	fake.SetStatus(fakeStatus(10, 1.5, 1403617468.5))
+	for i, want := range []bool{true, false} {
+		added, err := w.Poll(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != want {
+			t.Errorf("poll %d: got %v, want %v", i, added, want)
+		}
+	}
+	fake.SetStatus(fakeStatus(20, 0, 1403617528))
+	if _, err := w.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []StatusSample{
+		{time.Unix(1403617468, 5e8), 10, 1.5, 0, 0},
+		{time.Unix(1403617528, 0), 20, 0, 0, 0},
+	}
+	if got := w.Samples(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestStatusWatcherRing(t *testing.T) {
+	w := &StatusWatcher{Size: 3}
+	ch, cancel := w.Subscribe()
+	for i := 1; i <= 5; i++ {
+		w.Add(Status{Count: i, Timestamp: float64(i)})
+	}
+	w.Add(Status{Count: 0, Timestamp: 2})
+
+	var counts []int
+	for _, s := range w.Samples() {
+		counts = append(counts, s.Count)
+	}
+	if want := []int{3, 4, 5}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("got %v, want %v", counts, want)
+	}
+
+	if len(ch) != 5 {
+		t.Errorf("subscriber got %d samples, want 5", len(ch))
+	}
+	cancel()
+	w.Add(Status{Count: 6, Timestamp: 6})
+	if len(ch) != 5 {
+		t.Error("sample sent after unsubscribing")
+	}
+}
+
+func TestStatusWatcherNegativeSize(t *testing.T) {
+	w := &StatusWatcher{Size: -1}
+	w.Add(Status{Count: 1, Timestamp: 1})
+	if got := w.Samples(); len(got) != 1 || got[0].Count != 1 {
+		t.Errorf("got %v, want one sample", got)
+	}
+}
+
+func TestStatusCSV(t *testing.T) {
+	w := &StatusWatcher{}
+	w.Add(Status{Count: 10, SpyQueueTime: 1.25, SpyeeQueueTime: 30, Antinudepercent: 0.5, Timestamp: 1403617468.5})
+	w.Add(Status{Count: 11, Timestamp: 1403617528})
+
+	var buf bytes.Buffer
+	if err := w.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteStatusCSV(&buf, []StatusSample{{time.Unix(1403617588, 0), 12, 0, 0, 0}}, false); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadStatusCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(w.Samples(), StatusSample{time.Unix(1403617588, 0), 12, 0, 0, 0})
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if !got[i].Time.Equal(want[i].Time) || got[i].Count != want[i].Count || got[i].SpyQueueTime != want[i].SpyQueueTime ||
+			got[i].SpyeeQueueTime != want[i].SpyeeQueueTime || got[i].Antinudepercent != want[i].Antinudepercent {
+			t.Errorf("sample %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestStatusWatcherRun(t *testing.T) {
+	fake := gomegletest.NewServer()
+	defer fake.Close()
+	w := &StatusWatcher{Omegle: &Omegle{Client: &http.Client{Transport: fake.Transport()}}, Interval: time.Millisecond}
+	ch, cancel := w.Subscribe()
+	defer cancel()
+
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+	<-ch
+	fake.SetStatus(fakeStatus(99, 0, float64(time.Now().Unix()+60)))
+	if s := <-ch; s.Count != 99 {
+		t.Error("got a sample with count ", s.Count)
+	}
+	stop()
+	if err := <-done; err != context.Canceled {
+		t.Error("Run returned ", err)
+	}
+}
@@ -0,0 +1,140 @@
+package gomegle
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record is one thing that happened in a conversation
+type Record struct {
+	Time  time.Time
+	Event EventData // What happened
+	// True for what we did ourselves: MessageEvent for a message we sent,
+	// TYPING and STOPPEDTYPING for typing changes we showed and DISCONNECTED
+	// if we disconnected
+	Sent bool
+}
+
+// Transcript records everything that happens in the conversations of a
+// session. Set Omegle.Record to get one from Session.Transcript
+type Transcript struct {
+	m       sync.Mutex
+	records []Record
+	starts  []int // Where every conversation but the first starts in records
+}
+
+// Add a record
+func (t *Transcript) add(ev EventData, sent bool) {
+	defer t.m.Unlock()
+	t.m.Lock()
+	t.records = append(t.records, Record{time.Now(), ev, sent})
+}
+
+// Mark the start of a new conversation
+func (t *Transcript) newConversation() {
+	defer t.m.Unlock()
+	t.m.Lock()
+	t.starts = append(t.starts, len(t.records))
+}
+
+// Records returns everything recorded so far
+func (t *Transcript) Records() []Record {
+	defer t.m.Unlock()
+	t.m.Lock()
+	return append([]Record(nil), t.records...)
+}
+
+// Conversations splits the transcript into one transcript per conversation,
+// the session might have reconnected to new strangers
+func (t *Transcript) Conversations() []*Transcript {
+	defer t.m.Unlock()
+	t.m.Lock()
+
+	var convs []*Transcript
+	from := 0
+	for _, to := range append(t.starts, len(t.records)) {
+		convs = append(convs, &Transcript{records: append([]Record(nil), t.records[from:to]...)})
+		from = to
+	}
+	return convs
+}
+
+// Last returns the last conversation of the transcript, the one Generate
+// needs the LogEntries and IdentDigests of
+func (t *Transcript) Last() *Transcript {
+	convs := t.Conversations()
+	return convs[len(convs)-1]
+}
+
+// IdentDigests returns the ident digests of the last conversation, "" if there
+// were none. Pass them to Generate along with the LogEntries of Last, not of
+// the whole transcript
+func (t *Transcript) IdentDigests() string {
+	defer t.m.Unlock()
+	t.m.Lock()
+
+	from := 0
+	if len(t.starts) != 0 {
+		from = t.starts[len(t.starts)-1]
+	}
+	for i := len(t.records) - 1; i >= from; i-- {
+		if ev, ok := t.records[i].Event.(IdentDigestsEvent); ok {
+			return ev.Digests
+		}
+	}
+	return ""
+}
+
+// LogEntries converts the transcript into entries for Generate. Messages are
+// STR and YOU entries, or STR1 and STR2 if we were watching two strangers as
+// a spy. Questions are Q entries and connecting, common likes and
+// disconnecting are described in DEF entries. The entries cover every
+// conversation of the transcript, see Last and Conversations for a single one
+func (t *Transcript) LogEntries() []LogEntry {
+	records := t.Records()
+
+	spy := false
+	for _, r := range records {
+		switch r.Event.(type) {
+		case SpyMessageEvent, SpyTypingEvent, SpyDisconnectedEvent:
+			spy = true
+		}
+	}
+
+	var logs []LogEntry
+	for _, r := range records {
+		switch ev := r.Event.(type) {
+		case MessageEvent:
+			if r.Sent {
+				logs = append(logs, LogEntry{YOU, ev.Text, ""})
+			} else {
+				logs = append(logs, LogEntry{STR, ev.Text, ""})
+			}
+		case SpyMessageEvent:
+			if ev.Who == "Stranger 2" {
+				logs = append(logs, LogEntry{STR2, ev.Text, ""})
+			} else {
+				logs = append(logs, LogEntry{STR1, ev.Text, ""})
+			}
+		case QuestionEvent:
+			logs = append(logs, LogEntry{Q, ev.Text, ""})
+		case CommonLikesEvent:
+			logs = append(logs, LogEntry{DEF, "You both like " + strings.Join(ev.Topics, ", ") + ".", ""})
+		case SpyDisconnectedEvent:
+			logs = append(logs, LogEntry{DEF, ev.Who + " has disconnected", ""})
+		case Event:
+			switch {
+			case ev == CONNECTED && spy:
+				logs = append(logs, LogEntry{DEF, "You're now watching two strangers discuss your question!", ""})
+			case ev == CONNECTED:
+				logs = append(logs, LogEntry{DEF, "You're now chatting with a random stranger. Say hi!", ""})
+			case ev == DISCONNECTED && r.Sent:
+				logs = append(logs, LogEntry{DEF, "You have disconnected.", ""})
+			case ev == DISCONNECTED:
+				logs = append(logs, LogEntry{DEF, "Stranger has disconnected.", ""})
+			}
+		}
+	}
+	return logs
+}
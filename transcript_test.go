@@ -0,0 +1,127 @@
+package gomegle
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GiedriusS/gomegle/gomegletest"
+)
+
+func TestTranscript(t *testing.T) {
+	o := Omegle{Topics: []string{"cats"}, Record: true}
+	s, err := o.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Transcript() == nil {
+		t.Fatal("no transcript with Record set")
+	}
+
+	chat := srv.Lookup(s.ID())
+	chat.Send("hi")
+	for ev := range s.Events() {
+		if _, ok := ev.(MessageEvent); ok {
+			break
+		}
+	}
+	if err := s.ShowTyping(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendMessage("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := s.Transcript()
+	if tr.IdentDigests() != gomegletest.DefaultDigests {
+		t.Error("wrong ident digests: ", tr.IdentDigests())
+	}
+	var sent []EventData
+	for _, r := range tr.Records() {
+		if r.Time.IsZero() {
+			t.Error("record without a time: ", r)
+		}
+		if r.Sent {
+			sent = append(sent, r.Event)
+		}
+	}
+	if want := []EventData{TYPING, MessageEvent{"hello"}, DISCONNECTED}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("got sent records %v, want %v", sent, want)
+	}
+
+	want := []LogEntry{
+		{DEF, "You're now chatting with a random stranger. Say hi!", ""},
+		{DEF, "You both like cats.", ""},
+		{STR, "hi", ""},
+		{YOU, "hello", ""},
+		{DEF, "You have disconnected.", ""},
+	}
+	if got := tr.LogEntries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTranscriptSpy(t *testing.T) {
+	tr := &Transcript{}
+	tr.add(WAITING, false)
+	tr.add(CONNECTED, false)
+	tr.add(QuestionEvent{"Why?"}, false)
+	tr.add(SpyMessageEvent{"Stranger 1", "because"}, false)
+	tr.add(SpyMessageEvent{"Stranger 2", "why not"}, false)
+	tr.add(SpyDisconnectedEvent{"Stranger 2"}, false)
+
+	want := []LogEntry{
+		{DEF, "You're now watching two strangers discuss your question!", ""},
+		{Q, "Why?", ""},
+		{STR1, "because", ""},
+		{STR2, "why not", ""},
+		{DEF, "Stranger 2 has disconnected", ""},
+	}
+	if got := tr.LogEntries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTranscriptConversations(t *testing.T) {
+	o := Omegle{Record: true, Reconnect: &ReconnectPolicy{StrangerLeft: true, MinBackoff: time.Millisecond}}
+	s, err := o.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	srv.Lookup(s.ID()).Disconnect()
+	connected := 0
+	for connected < 2 {
+		if ev := <-s.Events(); ev == CONNECTED {
+			connected++
+		}
+	}
+
+	convs := s.Transcript().Conversations()
+	if len(convs) != 2 {
+		t.Fatal("expected 2 conversations, got ", len(convs))
+	}
+	first := convs[0].Records()
+	if first[len(first)-1].Event != DISCONNECTED {
+		t.Error("first conversation does not end with the stranger leaving")
+	}
+	if convs[1].Records()[0].Event != WAITING {
+		t.Error("second conversation does not start with WAITING")
+	}
+}
+
+func TestTranscriptLast(t *testing.T) {
+	tr := exampleTranscript()
+	want := []LogEntry{{Q, "Why?", ""}, {STR2, "because", ""}}
+	if got := tr.Last().LogEntries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := (&Transcript{}).Last().Records(); len(got) != 0 {
+		t.Error("got records from an empty transcript: ", got)
+	}
+}
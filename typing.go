@@ -0,0 +1,119 @@
+package gomegle
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Default values used when the fields of TypingSimulator are zero
+const (
+	DefaultWPM   = 40
+	DefaultPause = 2 * time.Second
+)
+
+// TypingSimulator sends messages the way a person typing them would: it
+// shows the stranger that we are typing, waits for as long as typing the
+// message takes and only then sends it. The zero value types at DefaultWPM
+// without jitter or pauses. The same simulator can be used by many sessions
+type TypingSimulator struct {
+	WPM    float64 // Optional, typing speed in words of 5 characters per minute, DefaultWPM if 0
+	Jitter float64 // Optional, random fraction (0 to 1) by which every wait is made shorter or longer
+	// Optional, chance (0 to 1) of pausing after every word, showing that we
+	// stopped typing for PauseLength before typing on
+	PauseChance float64
+	PauseLength time.Duration // Optional, DefaultPause if 0
+}
+
+// Delay returns how long typing text takes at WPM, without jitter or pauses
+func (t *TypingSimulator) Delay(text string) time.Duration {
+	wpm := t.WPM
+	if wpm <= 0 {
+		wpm = DefaultWPM
+	}
+	return time.Duration(float64(utf8.RuneCountInString(text)) / (wpm * 5) * float64(time.Minute))
+}
+
+// Make d randomly shorter or longer by up to Jitter
+func (t *TypingSimulator) jitter(d time.Duration) time.Duration {
+	if t.Jitter <= 0 {
+		return d
+	}
+	randomM.Lock()
+	f := random.Float64()*2 - 1
+	randomM.Unlock()
+	return d + time.Duration(float64(d)*t.Jitter*f)
+}
+
+// Whether to pause after a word
+func (t *TypingSimulator) pause() bool {
+	if t.PauseChance <= 0 {
+		return false
+	}
+	randomM.Lock()
+	defer randomM.Unlock()
+	return random.Float64() < t.PauseChance
+}
+
+// Wait for d in the current conversation of s
+func (t *TypingSimulator) wait(ctx context.Context, s *Session, ended <-chan struct{}, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ended:
+	case <-s.ctx.Done():
+	}
+	return &Error{"Send", ErrConversationEnded, "", 0}
+}
+
+// Send shows that we are typing msg, waits until it is typed and sends it
+// in the current conversation of s. Nothing is sent if ctx is done, then the
+// stranger is shown that we stopped typing, or if the conversation ends or s
+// is closed in the meantime, then the error wraps ErrConversationEnded
+func (t *TypingSimulator) Send(ctx context.Context, s *Session, msg string) (err error) {
+	if msg == "" {
+		return &Error{"Send", ErrEmptyMessage, "", 0}
+	}
+	ended := s.conversationEnded()
+	if err := s.ShowTypingContext(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		if ctx.Err() != nil {
+			s.StopTyping()
+		}
+	}()
+
+	words := strings.Fields(msg)
+	for i, w := range words {
+		if i != len(words)-1 {
+			w += " "
+		}
+		if err := t.wait(ctx, s, ended, t.jitter(t.Delay(w))); err != nil {
+			return err
+		}
+		if i == len(words)-1 || !t.pause() {
+			continue
+		}
+
+		if err := s.StopTypingContext(ctx); err != nil {
+			return err
+		}
+		pause := t.PauseLength
+		if pause <= 0 {
+			pause = DefaultPause
+		}
+		if err := t.wait(ctx, s, ended, t.jitter(pause)); err != nil {
+			return err
+		}
+		if err := s.ShowTypingContext(ctx); err != nil {
+			return err
+		}
+	}
+	return s.SendMessageContext(ctx, msg)
+}
@@ -0,0 +1,108 @@
+package gomegle
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTypingDelay(t *testing.T) {
+	if d := (&TypingSimulator{WPM: 60}).Delay("hello world!"); d != 2400*time.Millisecond {
+		t.Error("wrong delay at 60 WPM: ", d)
+	}
+	if d := (&TypingSimulator{}).Delay("hello"); d != 1500*time.Millisecond {
+		t.Error("wrong delay at the default WPM: ", d)
+	}
+}
+
+// Commands sent in the conversation with the given id
+func commands(id string) (cmds []string) {
+	for _, r := range srv.Requests() {
+		if r.Form.Get("id") == id && r.Cmd != "events" {
+			cmds = append(cmds, r.Cmd)
+		}
+	}
+	return cmds
+}
+
+func TestTypingSend(t *testing.T) {
+	var o Omegle
+	s, err := o.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	typist := &TypingSimulator{WPM: 6000, Jitter: 0.5, PauseChance: 1, PauseLength: time.Millisecond}
+	start := time.Now()
+	if err := typist.Send(context.Background(), s, "one two three"); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took < 10*time.Millisecond {
+		t.Error("sent without waiting, took ", took)
+	}
+	if got := srv.Lookup(s.ID()).Messages(); !reflect.DeepEqual(got, []string{"one two three"}) {
+		t.Error("got messages ", got)
+	}
+	want := []string{"typing", "stoppedtyping", "typing", "stoppedtyping", "typing", "send"}
+	if got := commands(s.ID()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got commands %v, want %v", got, want)
+	}
+}
+
+func TestTypingCancel(t *testing.T) {
+	var o Omegle
+	s, err := o.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	chat := srv.Lookup(s.ID())
+	typist := &TypingSimulator{WPM: 1}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- typist.Send(ctx, s, "hello") }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Error("expected context.Canceled, got ", err)
+	}
+	if chat.IsTyping() {
+		t.Error("still typing after ctx was done")
+	}
+
+	go func() { done <- typist.Send(context.Background(), s, "hello") }()
+	time.Sleep(50 * time.Millisecond)
+	chat.Disconnect()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrConversationEnded) {
+			t.Error("expected ErrConversationEnded, got ", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("kept typing after the stranger left")
+	}
+	if len(chat.Messages()) != 0 {
+		t.Error("sent a message anyway")
+	}
+}
+
+func TestTypingEmpty(t *testing.T) {
+	var o Omegle
+	s, err := o.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var typist TypingSimulator
+	if err := typist.Send(context.Background(), s, ""); !errors.Is(err, ErrEmptyMessage) {
+		t.Error("expected ErrEmptyMessage, got ", err)
+	}
+	if got := commands(s.ID()); len(got) != 0 {
+		t.Errorf("sent %v for an empty message", got)
+	}
+}